@@ -2,131 +2,452 @@ package main
 
 import (
 	"context"
-	"encoding/hex"
+	"errors"
+	"fmt"
 	"log"
 	"os"
 	"strconv"
+	"sync"
 	"time"
 
-	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/spacesprotocol/explorer-indexer/pkg/node"
 	"github.com/spacesprotocol/marketplace/pkg/db"
+	"github.com/spacesprotocol/marketplace/pkg/store"
+)
+
+const (
+	minBackoff = time.Second
+	maxBackoff = 2 * time.Minute
+)
+
+// verifyConcurrency is the number of requests made to the spaces node at
+// once, to verify listings or look up spaces
+var verifyConcurrency = 8
+
+// The indexer catches up by ranges of catchUpBatch blocks while it is at
+// least catchUpThreshold blocks behind the tip
+var (
+	catchUpThreshold = 144
+	catchUpBatch     = 500
+)
+
+// Every valid listing is verified again at least once every
+// revalidateInterval, by batches of revalidateBatch listings between syncs
+var (
+	revalidateInterval = 24 * time.Hour
+	revalidateBatch    = 100
 )
 
 func main() {
 	log.SetFlags(log.LstdFlags | log.Lshortfile)
-	spacesClient := node.NewClient(os.Getenv("SPACES_NODE_URI"), os.Getenv("RPC_USER"), os.Getenv("RPC_PASSWORD"))
+	sc := store.NewSpacesClient(os.Getenv("SPACES_NODE_URI"), os.Getenv("RPC_USER"), os.Getenv("RPC_PASSWORD"))
 	updateInterval, err := strconv.Atoi(os.Getenv("UPDATE_DB_INTERVAL"))
 	if err != nil {
 		log.Fatalln(err)
 	}
-	sc := node.SpacesClient{Client: spacesClient}
+	if codes := os.Getenv("VERIFY_REJECTION_CODES"); codes != "" {
+		if sc.RejectionCodes, err = store.ParseRejectionCodes(codes); err != nil {
+			log.Fatalln(err)
+		}
+	}
+	if v, err := strconv.Atoi(os.Getenv("VERIFY_CONCURRENCY")); err == nil && v > 0 {
+		verifyConcurrency = v
+	}
+	if v, err := strconv.Atoi(os.Getenv("CATCHUP_THRESHOLD")); err == nil && v > 0 {
+		catchUpThreshold = v
+	}
+	if v, err := strconv.Atoi(os.Getenv("CATCHUP_BATCH")); err == nil && v > 0 {
+		catchUpBatch = v
+	}
+	if v, err := strconv.Atoi(os.Getenv("REVALIDATE_INTERVAL")); err == nil && v > 0 {
+		revalidateInterval = time.Duration(v) * time.Second
+	}
+	if v, err := strconv.Atoi(os.Getenv("REVALIDATE_BATCH")); err == nil && v >= 0 {
+		revalidateBatch = v
+	}
 
+	// sales and pending transfers are only detected when a bitcoin node is
+	// configured
+	var bc *store.BitcoinClient
+	if uri := os.Getenv("BITCOIN_NODE_URI"); uri != "" {
+		bc = &store.BitcoinClient{RPCClient: store.NewRPCClient(uri, os.Getenv("BITCOIN_RPC_USER"), os.Getenv("BITCOIN_RPC_PASSWORD"))}
+	}
+
+	poolConfig, err := pgxpool.ParseConfig(os.Getenv("POSTGRES_URI"))
+	if err != nil {
+		log.Fatalf("unable to parse config: %v", err)
+	}
+	pg, err := pgxpool.NewWithConfig(context.Background(), poolConfig)
+	if err != nil {
+		log.Fatalf("unable to create connection pool: %v", err)
+	}
+	defer pg.Close()
+
+	// failed syncs are retried from the last committed block, backing off
+	// while the node or the db stays unavailable
+	backoff := minBackoff
 	for {
-		connCtx, cancel := context.WithTimeout(context.Background(), time.Minute)
-		pg, err := pgx.Connect(connCtx, os.Getenv("POSTGRES_URI"))
-		cancel()
-		if err != nil {
-			log.Printf("failed to connect to database: %v", err)
-			time.Sleep(time.Second)
+		if err := syncBlocks(pg, sc, bc); err != nil {
+			log.Printf("%v, retrying in %s", err, backoff)
+			time.Sleep(backoff)
+			backoff = min(2*backoff, maxBackoff)
 			continue
 		}
-
-		if err := syncBlocks(pg, &sc); err != nil {
-			log.Println(err)
-			pg.Close(context.Background())
-			time.Sleep(time.Second)
-			continue
+		backoff = minBackoff
+		if err := revalidateListings(pg, sc); err != nil {
+			log.Printf("failed to revalidate listings: %v", err)
+		}
+		if err := store.UpdatePending(context.Background(), db.New(pg), bc); err != nil {
+			log.Printf("failed to check the mempool: %v", err)
+		}
+		if err := db.New(pg).CompactListingsChanges(context.Background()); err != nil {
+			log.Printf("failed to compact the listings changes: %v", err)
 		}
-
-		pg.Close(context.Background())
 		time.Sleep(time.Duration(updateInterval) * time.Second)
 	}
 }
 
-func syncBlocks(pg *pgx.Conn, sc *node.SpacesClient) error {
-	q := db.New(pg)
+// spaceTouch is a space seen in a block together with the transaction and
+// the kind of space event which touched it. N is the output holding the
+// space after a create or an update.
+type spaceTouch struct {
+	Name      string
+	Txid      []byte
+	Event     string
+	N         int
+	Height    int32
+	BlockHash []byte
+}
+
+func syncBlocks(pg *pgxpool.Pool, sc *store.SpacesClient, bc *store.BitcoinClient) error {
 	ctx := context.Background()
-	sinfo, err := sc.GetServerInfo(ctx)
+	infoCtx, cancel := context.WithTimeout(ctx, store.RPCTimeout)
+	sinfo, err := sc.GetServerInfo(infoCtx)
+	cancel()
 	if err != nil {
 		return err
 	}
 
-	height, _, err := getSyncedHead(pg, sc)
+	height, err := getSyncedHead(pg, sc)
 	if err != nil {
 		return err
 	}
 
 	log.Printf("found the height %d in the db", height)
 
-	//invalidate all listings
 	height++
-	for {
-		if height > sinfo.Tip.Height {
-			break
+	for height <= sinfo.Tip.Height {
+		// far from the tip the blocks are applied by ranges, the listings
+		// touched anywhere in a range are verified once
+		count := 1
+		if sinfo.Tip.Height-height >= catchUpThreshold {
+			count = min(catchUpBatch, sinfo.Tip.Height-height+1)
+			log.Printf("catching up blocks %d to %d", height, height+count-1)
 		}
-		var seenNames []string
 
-		log.Printf("trying to get the block %d from the chain", height)
-		spacesBlock, err := sc.GetBlockMeta(ctx, height)
-		if err != nil {
-			break
+		blocks := make([]*node.SpacesBlock, 0, count)
+		for i := height; i < height+count; i++ {
+			log.Printf("trying to get the block %d from the chain", i)
+			blockCtx, cancel := context.WithTimeout(ctx, store.RPCTimeout)
+			spacesBlock, err := sc.GetBlockMeta(blockCtx, i)
+			cancel()
+			if err != nil {
+				return fmt.Errorf("failed to get block %d: %w", i, err)
+			}
+			blocks = append(blocks, spacesBlock)
+		}
+
+		if err := processBlocks(ctx, pg, sc, bc, height, blocks); err != nil {
+			return fmt.Errorf("failed to process blocks %d to %d: %w", height, height+count-1, err)
 		}
+		height += count
+	}
+	return nil
+}
+
+// processBlocks applies consecutive blocks starting at height in a single
+// transaction: the listing validity updates, the events, the sales and the
+// space states are committed together with the block rows, so a failure
+// leaves the blocks to be processed again. Everything needed from the nodes
+// is fetched before the transaction is opened, it only writes the results.
+func processBlocks(ctx context.Context, pg *pgxpool.Pool, sc *store.SpacesClient, bc *store.BitcoinClient, height int, blocks []*node.SpacesBlock) error {
+	var seenNames []spaceTouch
+	for i, spacesBlock := range blocks {
+		blockHeight := int32(height + i)
 		for _, tx := range spacesBlock.Transactions {
 			for _, created := range tx.Creates {
-				seenNames = append(seenNames, created.Name)
+				seenNames = append(seenNames, spaceTouch{Name: created.Name, Txid: tx.Txid, Event: store.SpaceEventCreate, N: created.N, Height: blockHeight, BlockHash: spacesBlock.Hash})
 			}
 			for _, updated := range tx.Updates {
-				seenNames = append(seenNames, updated.Output.Name)
+				seenNames = append(seenNames, spaceTouch{Name: updated.Output.Name, Txid: tx.Txid, Event: store.SpaceEventUpdate, N: updated.Output.N, Height: blockHeight, BlockHash: spacesBlock.Hash})
 			}
 			for _, spent := range tx.Spends {
 				if spent.ScriptError != nil {
-					seenNames = append(seenNames, spent.ScriptError.Name)
+					seenNames = append(seenNames, spaceTouch{Name: spent.ScriptError.Name, Txid: tx.Txid, Event: store.SpaceEventSpend, Height: blockHeight, BlockHash: spacesBlock.Hash})
 				}
 			}
+		}
+	}
+	tipHeight := int32(height + len(blocks) - 1)
 
+	// a space touched several times is checked once against the state at
+	// the tip, the last touch is the one recorded
+	lastTouch := map[string]spaceTouch{}
+	var names []string
+	for _, touch := range seenNames {
+		if len(touch.Name) == 0 || touch.Name[0] != '@' {
+			continue
+		}
+		name := touch.Name[1:]
+		if _, ok := lastTouch[name]; !ok {
+			names = append(names, name)
 		}
+		lastTouch[name] = touch
+	}
 
-		log.Printf("checking spaces: %s", seenNames)
-		for _, name := range seenNames {
-			var spaceName string
-			if len(name) > 0 && name[0] == '@' {
-				spaceName = name[1:]
-			}
+	q := db.New(pg)
+	log.Printf("checking spaces: %v", names)
+	listingsByName := make(map[string][]db.Listing, len(names))
+	var toVerify []db.Listing
+	for _, name := range names {
+		listings, err := q.GetVerifiableListingsByName(ctx, name)
+		if err != nil {
+			return err
+		}
+		listingsByName[name] = listings
+		toVerify = append(toVerify, listings...)
+	}
 
-			listings, err := q.GetListingByName(ctx, spaceName)
-			if err != nil {
-				return err
-			}
-			for _, listing := range listings {
-				sign := hex.EncodeToString(listing.Signature)
-				listingToCheck := node.Listing{Space: listing.Name, Seller: listing.Seller, Signature: sign, Price: int(listing.Price)}
-				listingToCheck.NormalizeSpace()
-				err = sc.VerifyListing(context.Background(), listingToCheck)
-				if err != nil {
-					listingValidityUpdate := db.UpdateListingValidityAndHeightParams{Signature: listing.Signature, Valid: false, Height: int32(height)}
-					q.UpdateListingValidityAndHeight(ctx, listingValidityUpdate)
-				} else {
-					listingValidityUpdate := db.UpdateListingValidityAndHeightParams{Signature: listing.Signature, Valid: true}
-					q.UpdateListingValidityAndHeight(ctx, listingValidityUpdate)
-				}
-			}
+	// sales are matched against the listings as they were before the blocks
+	var sales []db.InsertSaleParams
+	for _, touch := range seenNames {
+		if touch.Event != store.SpaceEventUpdate || len(touch.Name) == 0 || touch.Name[0] != '@' {
+			continue
+		}
+		sale, err := store.FindSale(ctx, bc, listingsByName[touch.Name[1:]], touch.Txid, touch.BlockHash, touch.N, touch.Height)
+		if err != nil {
+			return err
+		}
+		if sale != nil {
+			sales = append(sales, *sale)
+		}
+	}
+
+	verifyErrs := store.VerifyListings(ctx, sc, toVerify, verifyConcurrency)
+	for _, verifyErr := range verifyErrs {
+		// an unreachable node says nothing about the listing, the blocks
+		// are aborted and retried rather than invalidating it
+		if errors.Is(verifyErr, store.ErrNodeUnavailable) {
+			return verifyErr
+		}
+	}
+	verifiedAt := time.Now().Unix()
+
+	// only the listed spaces are stored, the spaces table decorates the
+	// listings and tracks their expiry
+	var touched []spaceTouch
+	for _, name := range names {
+		if len(listingsByName[name]) > 0 {
+			touch := lastTouch[name]
+			touch.Name = name
+			touched = append(touched, touch)
+		}
+	}
+	// the listed spaces no indexed block touched are looked up so that their
+	// expiry is known too
+	unseen, err := q.GetListedNamesWithoutSpace(ctx)
+	if err != nil {
+		return err
+	}
+	var lookups []string
+	for _, name := range unseen {
+		if _, ok := lastTouch[name]; !ok {
+			lookups = append(lookups, name)
+		}
+	}
+	spaces, err := getSpaceStates(ctx, sc, touched, lookups)
+	if err != nil {
+		return err
+	}
+
+	tx, err := pg.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	q = q.WithTx(tx)
+
+	for _, sale := range sales {
+		if err := q.InsertSale(ctx, sale); err != nil {
+			return err
 		}
+	}
 
-		err = q.UpsertBlock(ctx, db.UpsertBlockParams{Height: int32(height), Hash: spacesBlock.Hash})
+	signatures := make([][]byte, 0, len(toVerify))
+	for i, listing := range toVerify {
+		signatures = append(signatures, listing.Signature)
+		touch := lastTouch[listing.Name]
+		if err := store.ApplyVerification(ctx, q, listing, touch.Height, touch.Txid, touch.Event, verifyErrs[i]); err != nil {
+			return err
+		}
+	}
+
+	if err := q.SetListingsVerifiedAt(ctx, db.SetListingsVerifiedAtParams{VerifiedAt: verifiedAt, Signatures: signatures}); err != nil {
+		return err
+	}
+
+	for _, space := range spaces {
+		if err := q.UpsertSpace(ctx, space); err != nil {
+			return err
+		}
+	}
+
+	if err := store.InvalidateExpired(ctx, q, tipHeight); err != nil {
+		return err
+	}
+
+	for i, spacesBlock := range blocks {
+		if err := q.UpsertBlock(ctx, db.UpsertBlockParams{Height: int32(height + i), Hash: spacesBlock.Hash}); err != nil {
+			return err
+		}
+	}
+	return tx.Commit(ctx)
+}
+
+// getSpaceStates returns the chain state of the touched spaces, then of the
+// spaces to look up, with at most verifyConcurrency requests to the node at
+// once
+func getSpaceStates(ctx context.Context, sc *store.SpacesClient, touched []spaceTouch, lookups []string) ([]db.UpsertSpaceParams, error) {
+	spaces := make([]db.UpsertSpaceParams, len(touched)+len(lookups))
+	errs := make([]error, len(spaces))
+	sem := make(chan struct{}, max(verifyConcurrency, 1))
+	var wg sync.WaitGroup
+	for i := range spaces {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			if i < len(touched) {
+				touch := touched[i]
+				spaces[i], errs[i] = store.GetSpaceState(ctx, sc, touch.Name, touch.Txid, touch.N, touch.Height, touch.Event)
+			} else {
+				spaces[i], errs[i] = store.LookupSpace(ctx, sc, lookups[i-len(touched)])
+			}
+		}(i)
+	}
+	wg.Wait()
+	for _, err := range errs {
 		if err != nil {
+			return nil, err
+		}
+	}
+	return spaces, nil
+}
+
+// revalidateListings verifies again a batch of the valid listings verified
+// the longest ago, catching the invalidations the block scan missed. The
+// progress is kept in last_verified_at so the sweep resumes after a restart.
+func revalidateListings(pg *pgxpool.Pool, sc *store.SpacesClient) error {
+	if revalidateBatch == 0 {
+		return nil
+	}
+	ctx := context.Background()
+	q := db.New(pg)
+	now := time.Now()
+	listings, err := q.GetListingsToRevalidate(ctx, db.GetListingsToRevalidateParams{
+		VerifiedBefore: now.Add(-revalidateInterval).Unix(),
+		Limit:          int32(revalidateBatch),
+	})
+	if err != nil || len(listings) == 0 {
+		return err
+	}
+
+	verifyErrs := store.VerifyListings(ctx, sc, listings, verifyConcurrency)
+	for _, verifyErr := range verifyErrs {
+		if errors.Is(verifyErr, store.ErrNodeUnavailable) {
+			return verifyErr
+		}
+	}
+
+	tx, err := pg.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	q = q.WithTx(tx)
+
+	height, err := q.GetBlocksMaxHeight(ctx)
+	if err != nil {
+		return err
+	}
+	invalidated := 0
+	signatures := make([][]byte, 0, len(listings))
+	for i, listing := range listings {
+		signatures = append(signatures, listing.Signature)
+		if verifyErrs[i] == nil {
+			continue
+		}
+		invalidated++
+		if err := store.ApplyVerification(ctx, q, listing, height, nil, store.SpaceEventRevalidate, verifyErrs[i]); err != nil {
 			return err
 		}
-		height++
 	}
+	if err := q.SetListingsVerifiedAt(ctx, db.SetListingsVerifiedAtParams{VerifiedAt: now.Unix(), Signatures: signatures}); err != nil {
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+
+	log.Printf("revalidated %d listings, %d invalidated", len(listings), invalidated)
 	return nil
 }
 
-func getSyncedHead(pg *pgx.Conn, sc *node.SpacesClient) (int, []byte, error) {
+// getSyncedHead returns the height of the last db block which is still in the
+// best chain, rolling back the blocks and listings of an orphaned branch if
+// the chain has been reorganized since the last sync.
+func getSyncedHead(pg *pgxpool.Pool, sc *store.SpacesClient) (int, error) {
 	q := db.New(pg)
-	maxBlock, err := q.GetBlocksMaxHeight(context.Background())
+	ctx := context.Background()
+	maxBlock, err := q.GetBlocksMaxHeight(ctx)
+	if err != nil {
+		return 0, err
+	}
+	height, _, err := store.GetSyncedHead(ctx, q, sc)
+	if err != nil {
+		return 0, err
+	}
+	if height == maxBlock {
+		return int(height), nil
+	}
+
+	log.Printf("reorg detected: db tip %d, fork point %d", maxBlock, height)
+	// the listings changed by the orphaned blocks are verified again against
+	// the new chain before the transaction is opened
+	listings, err := q.GetListingsAfterHeight(ctx, height)
 	if err != nil {
-		return 0, nil, err
+		return 0, err
+	}
+	verifyErrs := store.VerifyListings(ctx, sc, listings, verifyConcurrency)
+	for _, verifyErr := range verifyErrs {
+		if errors.Is(verifyErr, store.ErrNodeUnavailable) {
+			return 0, verifyErr
+		}
+	}
+
+	tx, err := pg.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	if err := store.Rollback(ctx, q.WithTx(tx), height, listings, verifyErrs); err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return 0, err
 	}
-	return int(maxBlock), nil, nil
+	return int(height), nil
 }
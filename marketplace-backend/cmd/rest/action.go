@@ -9,17 +9,23 @@ import (
 	"strconv"
 	"strings"
 
-	"github.com/go-playground/validator/v10"
 	"github.com/jackc/pgx/v5/pgxpool"
-	"github.com/spacesprotocol/explorer-indexer/pkg/node"
 	"github.com/spacesprotocol/marketplace/pkg/db"
+	"github.com/spacesprotocol/marketplace/pkg/store"
 )
 
 type Action struct {
 	Method   string
+	Path     string
+	Cached   bool
 	Params   reflect.Type
 	Result   reflect.Type
 	Function reflect.Value
+	// Middleware wraps the handler of the action, the first one outermost
+	Middleware []func(http.HandlerFunc) http.HandlerFunc
+	// MaxBodyBytes bounds the request body, it is enforced before the body
+	// is read for logging. Zero leaves the body unbounded.
+	MaxBodyBytes int64
 }
 
 // NewAction creates a new Action from a function with the signature:
@@ -44,8 +50,112 @@ func NewAction(method string, function interface{}) *Action {
 	}
 }
 
+// WithPath sets the path pattern of the action. Segments in braces, e.g.
+// "/seller/{address}", are bound to the params field of the same name.
+func (a *Action) WithPath(path string) *Action {
+	a.Path = path
+	return a
+}
+
+// WithMiddleware wraps the handler of the action, see Action.Middleware
+func (a *Action) WithMiddleware(middleware ...func(http.HandlerFunc) http.HandlerFunc) *Action {
+	a.Middleware = append(a.Middleware, middleware...)
+	return a
+}
+
+// WithBodyLimit bounds the request body of the action to maxBytes
+func (a *Action) WithBodyLimit(maxBytes int64) *Action {
+	a.MaxBodyBytes = maxBytes
+	return a
+}
+
+// muxPattern returns the ServeMux pattern serving the path of the action: the
+// path itself, or the prefix before its first parameter
+func (a *Action) muxPattern() string {
+	if i := strings.Index(a.Path, "{"); i >= 0 {
+		return a.Path[:strings.LastIndex(a.Path[:i], "/")+1]
+	}
+	return a.Path
+}
+
+// matchPath matches the request path against the action path pattern and
+// returns the values of the path parameters
+func (a *Action) matchPath(path string) (map[string]string, bool) {
+	values := map[string]string{}
+	if a.Path == "" {
+		return values, true
+	}
+	patternSegments := strings.Split(strings.Trim(a.Path, "/"), "/")
+	pathSegments := strings.Split(strings.Trim(path, "/"), "/")
+	if len(patternSegments) != len(pathSegments) {
+		return nil, false
+	}
+	for i, segment := range patternSegments {
+		if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+			if pathSegments[i] == "" {
+				return nil, false
+			}
+			values[segment[1:len(segment)-1]] = pathSegments[i]
+		} else if segment != pathSegments[i] {
+			return nil, false
+		}
+	}
+	return values, true
+}
+
+// setField parses a query parameter value into a params field. Pointer fields
+// are allocated so that handlers can tell an absent filter from a zero value.
+func setField(field reflect.Value, value string) error {
+	if field.Kind() == reflect.Ptr {
+		ptr := reflect.New(field.Type().Elem())
+		if err := setField(ptr.Elem(), value); err != nil {
+			return err
+		}
+		field.Set(ptr)
+		return nil
+	}
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		val, err := strconv.ParseInt(value, 10, field.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("must be an integer")
+		}
+		field.SetInt(val)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		val, err := strconv.ParseUint(value, 10, field.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("must be a non-negative integer")
+		}
+		field.SetUint(val)
+	case reflect.Float32, reflect.Float64:
+		val, err := strconv.ParseFloat(value, field.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("must be a number")
+		}
+		field.SetFloat(val)
+	case reflect.Bool:
+		val, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("must be a boolean")
+		}
+		field.SetBool(val)
+	}
+	return nil
+}
+
+// headerWriter is implemented by results which carry part of the response,
+// such as paging metadata, in headers
+type headerWriter interface {
+	WriteHeaders(h http.Header)
+}
+
 // writeResult writes the result as JSON response
 func (a *Action) writeResult(w http.ResponseWriter, result interface{}) {
+	if hw, ok := result.(headerWriter); ok {
+		hw.WriteHeaders(w.Header())
+	}
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(result); err != nil {
 		log.Printf("failed to encode response: %v", err)
@@ -57,17 +167,13 @@ func (a *Action) writeResult(w http.ResponseWriter, result interface{}) {
 func (a *Action) parseBody(r *http.Request, ctx *Context) (reflect.Value, error) {
 	params := reflect.New(a.Params)
 	if err := json.NewDecoder(r.Body).Decode(params.Interface()); err != nil {
-		return reflect.Value{}, fmt.Errorf("failed to decode request body: %w", err)
+		return reflect.Value{}, InvalidInput("failed to decode request body: " + err.Error())
 	}
 
 	// Validate if the struct implements validation tags
 	if ctx.Validator != nil {
 		if err := ctx.Validator.Struct(params.Interface()); err != nil {
-			if validationErrs, ok := err.(validator.ValidationErrors); ok {
-				// Just return the validation error directly
-				return reflect.Value{}, validationErrs
-			}
-			return reflect.Value{}, err
+			return reflect.Value{}, validationError(err)
 		}
 	}
 
@@ -75,17 +181,30 @@ func (a *Action) parseBody(r *http.Request, ctx *Context) (reflect.Value, error)
 }
 
 // BuildHandler creates an http.HandlerFunc for this action with validation
-func (a *Action) BuildHandler(tx *pgxpool.Pool, spacesClient node.SpacesClient) http.HandlerFunc {
+func (a *Action) BuildHandler(tx *pgxpool.Pool, spacesClient *store.SpacesClient) http.HandlerFunc {
+	handler := a.buildHandler(tx, spacesClient)
+	for i := len(a.Middleware) - 1; i >= 0; i-- {
+		handler = a.Middleware[i](handler)
+	}
+	return handler
+}
+
+func (a *Action) buildHandler(tx *pgxpool.Pool, spacesClient *store.SpacesClient) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != a.Method {
-			w.WriteHeader(http.StatusMethodNotAllowed)
+			writeError(w, MethodNotAllowed(r.Method))
+			return
+		}
+
+		pathParams, ok := a.matchPath(r.URL.Path)
+		if !ok {
+			writeError(w, NotFound("not found"))
 			return
 		}
 
 		dbTx, err := tx.Begin(r.Context())
 		if err != nil {
-			log.Printf("failed to begin transaction: %v", err)
-			w.WriteHeader(http.StatusInternalServerError)
+			writeError(w, Internal("failed to begin transaction", err))
 			return
 		}
 		defer dbTx.Rollback(r.Context())
@@ -97,14 +216,7 @@ func (a *Action) BuildHandler(tx *pgxpool.Pool, spacesClient node.SpacesClient)
 		if a.Method == http.MethodPost || a.Method == http.MethodPut {
 			params, err = a.parseBody(r, ctx)
 			if err != nil {
-				if validationErrs, ok := err.(validator.ValidationErrors); ok {
-					w.WriteHeader(http.StatusBadRequest)
-					// Return the validation errors directly
-					json.NewEncoder(w).Encode(validationErrs)
-					return
-				}
-				log.Printf("failed to parse body: %v", err)
-				w.WriteHeader(http.StatusBadRequest)
+				writeError(w, err)
 				return
 			}
 		} else {
@@ -115,68 +227,104 @@ func (a *Action) BuildHandler(tx *pgxpool.Pool, spacesClient node.SpacesClient)
 					if len(values) > 0 {
 						field := params.FieldByName(strings.Title(key))
 						if field.IsValid() {
-							switch field.Kind() {
-							case reflect.String:
-								field.SetString(values[0])
-							case reflect.Int:
-								if val, err := strconv.Atoi(values[0]); err == nil {
-									field.SetInt(int64(val))
-								}
+							if err := setField(field, values[0]); err != nil {
+								writeError(w, InvalidInput("invalid parameters", ValidationError{Field: key, Message: err.Error()}))
+								return
 							}
 						}
 					}
 				}
 			}
 			// Handle path parameters
-			if strings.HasPrefix(r.URL.Path, "/space/") {
-				name := strings.TrimPrefix(r.URL.Path, "/space/")
-				if field := params.FieldByName("Name"); field.IsValid() {
-					field.SetString(name)
+			for key, value := range pathParams {
+				if field := params.FieldByName(strings.Title(key)); field.IsValid() && field.Kind() == reflect.String {
+					field.SetString(value)
 				}
 			}
 
 			// Validate query parameters
 			if ctx.Validator != nil {
 				if err := ctx.Validator.Struct(params.Interface()); err != nil {
-					if validationErrs, ok := err.(validator.ValidationErrors); ok {
-						w.WriteHeader(http.StatusBadRequest)
-						json.NewEncoder(w).Encode(validationErrs)
-						return
-					}
-					w.WriteHeader(http.StatusBadRequest)
-					json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+					writeError(w, validationError(err))
 					return
 				}
 			}
 		}
 
+		if a.Cached {
+			fresh, err := checkCache(ctx, w, r)
+			if err != nil {
+				writeError(w, err)
+				return
+			}
+			if fresh {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		}
+
 		out := a.Function.Call([]reflect.Value{
 			reflect.ValueOf(ctx),
 			params,
 		})
 
 		if !out[1].IsNil() {
-			err := out[1].Interface().(error)
-
-			errMsg := err.Error()
-			if strings.Contains(errMsg, "no listing found") || strings.Contains(errMsg, "not found") {
-				w.WriteHeader(http.StatusNotFound)
-				json.NewEncoder(w).Encode(map[string]string{"error": errMsg})
-				return
-			}
-
-			log.Printf("handler error: %v", err)
-			w.WriteHeader(http.StatusInternalServerError)
-			json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			writeError(w, out[1].Interface().(error))
 			return
 		}
 
 		if err := dbTx.Commit(r.Context()); err != nil {
-			log.Printf("failed to commit transaction: %v", err)
-			w.WriteHeader(http.StatusInternalServerError)
+			writeError(w, dbWriteError("failed to commit transaction", err))
 			return
 		}
 
 		a.writeResult(w, out[0].Interface())
 	}
 }
+
+// Routes dispatches requests sharing a path prefix to the action whose path
+// pattern matches the request path
+type Routes []*Action
+
+// BuildHandler creates an http.HandlerFunc serving all the routes
+func (routes Routes) BuildHandler(tx *pgxpool.Pool, spacesClient *store.SpacesClient) http.HandlerFunc {
+	handlers := make([]http.HandlerFunc, len(routes))
+	for i, action := range routes {
+		handlers[i] = action.BuildHandler(tx, spacesClient)
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		for i, action := range routes {
+			if _, ok := action.matchPath(r.URL.Path); ok {
+				handlers[i](w, r)
+				return
+			}
+		}
+		writeError(w, NotFound("not found"))
+	}
+}
+
+// Register serves the routes on mux. The routes sharing a pattern, such as
+// /space/{name} and /space/{name}/sales, are served by one logged handler.
+func (routes Routes) Register(mux *http.ServeMux, tx *pgxpool.Pool, spacesClient *store.SpacesClient) {
+	var patterns []string
+	groups := map[string]Routes{}
+	for _, action := range routes {
+		pattern := action.muxPattern()
+		if _, ok := groups[pattern]; !ok {
+			patterns = append(patterns, pattern)
+		}
+		groups[pattern] = append(groups[pattern], action)
+	}
+	for _, pattern := range patterns {
+		group := groups[pattern]
+		handler := group.BuildLoggedHandler(tx, spacesClient)
+		var maxBodyBytes int64
+		for _, action := range group {
+			maxBodyBytes = max(maxBodyBytes, action.MaxBodyBytes)
+		}
+		if maxBodyBytes > 0 {
+			handler = withBodyLimit(maxBodyBytes, handler)
+		}
+		mux.HandleFunc(pattern, handler)
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMatchPath(t *testing.T) {
+	tests := []struct {
+		name    string
+		pattern string
+		path    string
+		want    map[string]string
+		ok      bool
+	}{
+		{name: "no pattern", path: "/anything", want: map[string]string{}, ok: true},
+		{name: "static", pattern: "/listings", path: "/listings", want: map[string]string{}, ok: true},
+		{name: "trailing slash", pattern: "/listings", path: "/listings/", want: map[string]string{}, ok: true},
+		{name: "static mismatch", pattern: "/listings", path: "/sales", ok: false},
+		{name: "param", pattern: "/space/{name}", path: "/space/bob", want: map[string]string{"name": "bob"}, ok: true},
+		{name: "param and static", pattern: "/space/{name}/sales", path: "/space/bob/sales", want: map[string]string{"name": "bob"}, ok: true},
+		{name: "static after param mismatch", pattern: "/space/{name}/sales", path: "/space/bob/history", ok: false},
+		{name: "empty param", pattern: "/space/{name}/sales", path: "/space//sales", ok: false},
+		{name: "missing segment", pattern: "/space/{name}", path: "/space", ok: false},
+		{name: "extra segment", pattern: "/space/{name}", path: "/space/bob/sales", ok: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			action := &Action{Path: tt.pattern}
+			got, ok := action.matchPath(tt.path)
+			if ok != tt.ok {
+				t.Fatalf("matched %v, want %v", ok, tt.ok)
+			}
+			if ok && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("params %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSetField(t *testing.T) {
+	var params struct {
+		String  string
+		Int     int
+		Int32   int32
+		Uint    uint
+		Float   float64
+		Bool    bool
+		Pointer *int
+	}
+	fields := reflect.ValueOf(&params).Elem()
+
+	tests := []struct {
+		field   string
+		value   string
+		want    interface{}
+		wantErr string
+	}{
+		{field: "String", value: "bob", want: "bob"},
+		{field: "Int", value: "-42", want: -42},
+		{field: "Int", value: "4.2", wantErr: "must be an integer"},
+		{field: "Int32", value: "2147483648", wantErr: "must be an integer"},
+		{field: "Int32", value: "2147483647", want: int32(2147483647)},
+		{field: "Uint", value: "7", want: uint(7)},
+		{field: "Uint", value: "-7", wantErr: "must be a non-negative integer"},
+		{field: "Float", value: "0.5", want: 0.5},
+		{field: "Float", value: "half", wantErr: "must be a number"},
+		{field: "Bool", value: "true", want: true},
+		{field: "Bool", value: "yes", wantErr: "must be a boolean"},
+		{field: "Pointer", value: "0", want: 0},
+		{field: "Pointer", value: "zero", wantErr: "must be an integer"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.field+"="+tt.value, func(t *testing.T) {
+			field := fields.FieldByName(tt.field)
+			field.Set(reflect.Zero(field.Type()))
+			err := setField(field, tt.value)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("error %v, want %q", err, tt.wantErr)
+				}
+				if field.Kind() == reflect.Ptr && !field.IsNil() {
+					t.Error("pointer set on error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("error %v", err)
+			}
+			got := field
+			if got.Kind() == reflect.Ptr {
+				if got.IsNil() {
+					t.Fatal("pointer not allocated")
+				}
+				got = got.Elem()
+			}
+			if !reflect.DeepEqual(got.Interface(), tt.want) {
+				t.Errorf("field %v, want %v", got.Interface(), tt.want)
+			}
+		})
+	}
+}
+
+func TestMuxPattern(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{path: "/listings", want: "/listings"},
+		{path: "/space/{name}", want: "/space/"},
+		{path: "/space/{name}/sales", want: "/space/"},
+		{path: "/seller/{address}", want: "/seller/"},
+		{path: "/v1/space/{name}", want: "/v1/space/"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			if got := (&Action{Path: tt.path}).muxPattern(); got != tt.want {
+				t.Errorf("pattern %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
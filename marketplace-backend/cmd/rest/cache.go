@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// WithCaching makes a GET action emit ETag and Last-Modified validators and
+// answer conditional requests with 304. Listings only change when a listing
+// is posted or the indexer processes a block, so the validators are derived
+// from the latest indexed block and the listings change counter. The counter
+// and the modification time move when a writing transaction commits.
+func (a *Action) WithCaching() *Action {
+	a.Cached = true
+	return a
+}
+
+// checkCache sets the cache validators of the response and reports whether
+// the client copy is still fresh
+func checkCache(ctx *Context, w http.ResponseWriter, r *http.Request) (bool, error) {
+	version, err := ctx.DB.GetCacheVersion(ctx)
+	if err != nil {
+		return false, Internal("failed to get cache version", err)
+	}
+
+	hash := version.Hash
+	if len(hash) > 4 {
+		hash = hash[:4]
+	}
+	etag := fmt.Sprintf(`W/"%d-%x-%d"`, version.Height, hash, version.Version)
+	lastModified := time.Unix(version.UpdatedAt, 0).UTC()
+
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Last-Modified", lastModified.Format(http.TimeFormat))
+	w.Header().Set("Cache-Control", "public, no-cache")
+
+	if match := r.Header.Get("If-None-Match"); match != "" {
+		for _, candidate := range strings.Split(match, ",") {
+			candidate = strings.TrimSpace(candidate)
+			if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+	if since, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err == nil {
+		return !lastModified.After(since), nil
+	}
+	return false, nil
+}
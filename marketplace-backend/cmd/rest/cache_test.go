@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/spacesprotocol/marketplace/pkg/db"
+)
+
+// versionDB answers GetCacheVersion with a fixed version
+type versionDB struct {
+	version db.GetCacheVersionRow
+}
+
+func (v versionDB) Exec(context.Context, string, ...interface{}) (pgconn.CommandTag, error) {
+	panic("unexpected exec")
+}
+
+func (v versionDB) Query(context.Context, string, ...interface{}) (pgx.Rows, error) {
+	panic("unexpected query")
+}
+
+func (v versionDB) QueryRow(context.Context, string, ...interface{}) pgx.Row {
+	return v
+}
+
+func (v versionDB) Scan(dest ...interface{}) error {
+	*dest[0].(*int32) = v.version.Height
+	*dest[1].(*[]byte) = v.version.Hash
+	*dest[2].(*int64) = v.version.Version
+	*dest[3].(*int64) = v.version.UpdatedAt
+	return nil
+}
+
+func TestCheckCache(t *testing.T) {
+	updatedAt := time.Date(2025, 10, 18, 12, 0, 0, 0, time.UTC)
+	version := db.GetCacheVersionRow{Height: 812, Hash: []byte{0xab, 0xcd, 0xef, 0x01, 0x23}, Version: 7, UpdatedAt: updatedAt.Unix()}
+	etag := `W/"812-abcdef01-7"`
+
+	tests := []struct {
+		name    string
+		headers map[string]string
+		fresh   bool
+	}{
+		{name: "no validators"},
+		{name: "matching etag", headers: map[string]string{"If-None-Match": etag}, fresh: true},
+		{name: "strong form of the etag", headers: map[string]string{"If-None-Match": `"812-abcdef01-7"`}, fresh: true},
+		{name: "etag in a list", headers: map[string]string{"If-None-Match": `W/"811-abcdef01-7", ` + etag}, fresh: true},
+		{name: "any etag", headers: map[string]string{"If-None-Match": "*"}, fresh: true},
+		{name: "stale etag", headers: map[string]string{"If-None-Match": `W/"812-abcdef01-6"`}},
+		{name: "not modified since", headers: map[string]string{"If-Modified-Since": updatedAt.Format(http.TimeFormat)}, fresh: true},
+		{name: "modified since", headers: map[string]string{"If-Modified-Since": updatedAt.Add(-time.Second).Format(http.TimeFormat)}},
+		{name: "etag takes precedence", headers: map[string]string{
+			"If-None-Match":     `W/"812-abcdef01-6"`,
+			"If-Modified-Since": updatedAt.Format(http.TimeFormat),
+		}},
+		{name: "invalid date", headers: map[string]string{"If-Modified-Since": "yesterday"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := &Context{Context: context.Background(), DB: db.New(versionDB{version: version})}
+			r := httptest.NewRequest(http.MethodGet, "/listings", nil)
+			for key, value := range tt.headers {
+				r.Header.Set(key, value)
+			}
+			w := httptest.NewRecorder()
+
+			fresh, err := checkCache(ctx, w, r)
+			if err != nil {
+				t.Fatalf("error %v", err)
+			}
+			if fresh != tt.fresh {
+				t.Errorf("fresh %v, want %v", fresh, tt.fresh)
+			}
+			if got := w.Header().Get("ETag"); got != etag {
+				t.Errorf("ETag %q, want %q", got, etag)
+			}
+			if got := w.Header().Get("Last-Modified"); got != updatedAt.Format(http.TimeFormat) {
+				t.Errorf("Last-Modified %q", got)
+			}
+		})
+	}
+}
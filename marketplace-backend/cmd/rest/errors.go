@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/spacesprotocol/marketplace/pkg/store"
+)
+
+// Machine-readable error codes of the API
+const (
+	CodeNotFound             = "not_found"
+	CodeInvalidInput         = "invalid_input"
+	CodeVerificationRejected = "verification_rejected"
+	CodeUpstreamUnavailable  = "upstream_unavailable"
+	CodeUnavailable          = "unavailable"
+	CodeConflict             = "conflict"
+	CodeMethodNotAllowed     = "method_not_allowed"
+	CodeRateLimited          = "rate_limited"
+	CodePayloadTooLarge      = "payload_too_large"
+	CodeInternal             = "internal_error"
+)
+
+// APIError is an error returned by a handler carrying the HTTP status and
+// error code of the response. Err is the underlying cause, it is logged but
+// never sent to the client.
+type APIError struct {
+	Status  int
+	Code    string
+	Message string
+	Fields  []ValidationError
+	Err     error
+}
+
+func (e *APIError) Error() string {
+	if e.Err != nil {
+		return e.Message + ": " + e.Err.Error()
+	}
+	return e.Message
+}
+
+func (e *APIError) Unwrap() error {
+	return e.Err
+}
+
+// ErrorResponse is the JSON envelope of every error response
+type ErrorResponse struct {
+	Error  string            `json:"error"`
+	Code   string            `json:"code"`
+	Fields []ValidationError `json:"fields,omitempty"`
+}
+
+func NotFound(message string) *APIError {
+	return &APIError{Status: http.StatusNotFound, Code: CodeNotFound, Message: message}
+}
+
+func InvalidInput(message string, fields ...ValidationError) *APIError {
+	return &APIError{Status: http.StatusBadRequest, Code: CodeInvalidInput, Message: message, Fields: fields}
+}
+
+func VerificationRejected(message string) *APIError {
+	return &APIError{Status: http.StatusUnprocessableEntity, Code: CodeVerificationRejected, Message: message}
+}
+
+func UpstreamUnavailable(err error) *APIError {
+	return &APIError{Status: http.StatusServiceUnavailable, Code: CodeUpstreamUnavailable, Message: "spaces node is unavailable", Err: err}
+}
+
+func Unavailable(message string) *APIError {
+	return &APIError{Status: http.StatusServiceUnavailable, Code: CodeUnavailable, Message: message}
+}
+
+func Conflict(message string, err error) *APIError {
+	return &APIError{Status: http.StatusConflict, Code: CodeConflict, Message: message, Err: err}
+}
+
+func MethodNotAllowed(method string) *APIError {
+	return &APIError{Status: http.StatusMethodNotAllowed, Code: CodeMethodNotAllowed, Message: "method " + method + " is not allowed"}
+}
+
+func TooManyRequests() *APIError {
+	return &APIError{Status: http.StatusTooManyRequests, Code: CodeRateLimited, Message: "too many requests, try again later"}
+}
+
+func PayloadTooLarge() *APIError {
+	return &APIError{Status: http.StatusRequestEntityTooLarge, Code: CodePayloadTooLarge, Message: "request body too large"}
+}
+
+func Internal(message string, err error) *APIError {
+	return &APIError{Status: http.StatusInternalServerError, Code: CodeInternal, Message: message, Err: err}
+}
+
+// validationError converts validator errors into an invalid input error
+// listing the offending fields
+func validationError(err error) *APIError {
+	var validationErrs validator.ValidationErrors
+	if !errors.As(err, &validationErrs) {
+		return InvalidInput(err.Error())
+	}
+	fields := make([]ValidationError, 0, len(validationErrs))
+	for _, fieldErr := range validationErrs {
+		fields = append(fields, ValidationError{Field: fieldErr.Field(), Message: getValidationErrorMsg(fieldErr)})
+	}
+	return InvalidInput("invalid parameters", fields...)
+}
+
+// verifyError classifies an error of VerifyListing: a listing rejected by the
+// node is reported with the node's reason, a node which could not be asked is
+// unavailable
+func verifyError(err error) *APIError {
+	var rejection *store.RejectionError
+	if errors.As(err, &rejection) {
+		return VerificationRejected(rejection.Reason)
+	}
+	if errors.Is(err, store.ErrNodeUnavailable) {
+		return UpstreamUnavailable(err)
+	}
+	return Internal("failed to verify listing", err)
+}
+
+// dbWriteError classifies a failed write, constraint and serialization
+// failures are conflicts with concurrent requests
+func dbWriteError(message string, err error) *APIError {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && (pgErr.Code == "23505" || pgErr.Code == "40001") {
+		return Conflict(message, err)
+	}
+	return Internal(message, err)
+}
+
+// writeError writes the error envelope, untyped errors are internal errors
+// whose text is only logged
+func writeError(w http.ResponseWriter, err error) {
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		apiErr = Internal("internal error", err)
+	}
+	if apiErr.Status >= http.StatusInternalServerError {
+		log.Printf("handler error: %v", apiErr)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(apiErr.Status)
+	json.NewEncoder(w).Encode(ErrorResponse{Error: apiErr.Message, Code: apiErr.Code, Fields: apiErr.Fields})
+}
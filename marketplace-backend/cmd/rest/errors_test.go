@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestWriteError(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		wantStatus int
+		want       ErrorResponse
+	}{
+		{
+			name:       "typed error",
+			err:        NotFound("listing not found"),
+			wantStatus: http.StatusNotFound,
+			want:       ErrorResponse{Error: "listing not found", Code: CodeNotFound},
+		},
+		{
+			name:       "wrapped typed error",
+			err:        fmt.Errorf("handler: %w", TooManyRequests()),
+			wantStatus: http.StatusTooManyRequests,
+			want:       ErrorResponse{Error: "too many requests, try again later", Code: CodeRateLimited},
+		},
+		{
+			name:       "fields",
+			err:        InvalidInput("invalid parameters", ValidationError{Field: "limit", Message: "Must be less than or equal to 100"}),
+			wantStatus: http.StatusBadRequest,
+			want: ErrorResponse{Error: "invalid parameters", Code: CodeInvalidInput,
+				Fields: []ValidationError{{Field: "limit", Message: "Must be less than or equal to 100"}}},
+		},
+		{
+			name:       "body too large",
+			err:        PayloadTooLarge(),
+			wantStatus: http.StatusRequestEntityTooLarge,
+			want:       ErrorResponse{Error: "request body too large", Code: CodePayloadTooLarge},
+		},
+		{
+			name:       "cause not sent",
+			err:        Internal("failed to get listings", errors.New("connection refused")),
+			wantStatus: http.StatusInternalServerError,
+			want:       ErrorResponse{Error: "failed to get listings", Code: CodeInternal},
+		},
+		{
+			name:       "untyped error",
+			err:        errors.New("pq: password authentication failed"),
+			wantStatus: http.StatusInternalServerError,
+			want:       ErrorResponse{Error: "internal error", Code: CodeInternal},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			writeError(w, tt.err)
+			if w.Code != tt.wantStatus {
+				t.Errorf("status %d, want %d", w.Code, tt.wantStatus)
+			}
+			if got := w.Header().Get("Content-Type"); got != "application/json" {
+				t.Errorf("content type %q", got)
+			}
+			var got ErrorResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+				t.Fatalf("body %q: %v", w.Body.String(), err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("body %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidationError(t *testing.T) {
+	type params struct {
+		Sort  string `json:"sort_by" validate:"omitempty,oneof=price timestamp"`
+		Limit int    `json:"limit" validate:"omitempty,min=1,max=100"`
+		Name  string `json:"name" validate:"required"`
+	}
+	tests := []struct {
+		name   string
+		params params
+		want   []ValidationError
+	}{
+		{
+			name:   "one field",
+			params: params{Name: "bob", Limit: 101},
+			want:   []ValidationError{{Field: "limit", Message: "Must be less than or equal to 100"}},
+		},
+		{
+			name:   "several fields",
+			params: params{Sort: "name"},
+			want: []ValidationError{
+				{Field: "sort_by", Message: "Must be one of: price timestamp"},
+				{Field: "name", Message: "This field is required"},
+			},
+		},
+	}
+	v := NewContext(context.Background(), nil, nil).Validator
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			apiErr := validationError(v.Struct(tt.params))
+			if apiErr.Status != http.StatusBadRequest || apiErr.Code != CodeInvalidInput {
+				t.Errorf("status %d code %q, want an invalid input", apiErr.Status, apiErr.Code)
+			}
+			if !reflect.DeepEqual(apiErr.Fields, tt.want) {
+				t.Errorf("fields %+v, want %+v", apiErr.Fields, tt.want)
+			}
+		})
+	}
+
+	apiErr := validationError(errors.New("validator: (nil *main.params)"))
+	if apiErr.Code != CodeInvalidInput || len(apiErr.Fields) != 0 {
+		t.Errorf("other error %+v, want an invalid input without fields", apiErr)
+	}
+}
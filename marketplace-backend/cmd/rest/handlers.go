@@ -1,12 +1,18 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"encoding/hex"
+	"errors"
 	"fmt"
-	"log"
+	"strings"
+	"time"
 
+	"github.com/jackc/pgx/v5"
 	"github.com/spacesprotocol/explorer-indexer/pkg/node"
 	"github.com/spacesprotocol/marketplace/pkg/db"
+	"github.com/spacesprotocol/marketplace/pkg/store"
 )
 
 // Parameter and result types
@@ -15,24 +21,284 @@ type GetListingParams struct {
 }
 
 type GetListingsParams struct {
-	Sort_by    string `json:"sort_by" validate:"omitempty,oneof=price timestamp"`
-	Sort_order string `json:"sort_order" validate:"omitempty,oneof=asc desc"`
-	Limit      int    `json:"limit" validate:"omitempty,min=1,max=100"`
-	Offset     int    `json:"offset" validate:"omitempty,min=0"`
+	Search      string `json:"search" validate:"omitempty,max=63"`
+	Search_mode string `json:"search_mode" validate:"omitempty,oneof=any prefix substring fuzzy"`
+	Min_price   *int64 `json:"min_price" validate:"omitempty,min=0"`
+	Max_price   *int64 `json:"max_price" validate:"omitempty,min=0"`
+	Min_length  *int   `json:"min_length" validate:"omitempty,min=1,max=63"`
+	Max_length  *int   `json:"max_length" validate:"omitempty,min=1,max=63"`
+	Charset     string `json:"charset" validate:"omitempty,oneof=digits letters idn"`
+	Seller      string `json:"seller" validate:"omitempty,max=150"`
+	Min_height  *int   `json:"min_height" validate:"omitempty,min=0"`
+	Max_height  *int   `json:"max_height" validate:"omitempty,min=0"`
+	Sort_by     string `json:"sort_by" validate:"omitempty,oneof=price timestamp relevance"`
+	Sort_order  string `json:"sort_order" validate:"omitempty,oneof=asc desc"`
+	Limit       int    `json:"limit" validate:"omitempty,min=1,max=100"`
+	Cursor      string `json:"cursor" validate:"omitempty,max=512"`
+	// Deprecated: use Cursor, Offset is ignored when a cursor is given.
+	Offset int `json:"offset" validate:"omitempty,min=0"`
 }
 
-type ResponseListing struct {
+// likeEscaper escapes the LIKE pattern characters of a search term
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+type GetSpacesParams struct {
+	Names []string `json:"names" validate:"required,min=1,max=500,dive,required,max=64"`
+}
+
+type ResponseSpaceLookup struct {
+	Space   string           `json:"space"`
+	Found   bool             `json:"found"`
+	Listing *ResponseListing `json:"listing,omitempty"`
+}
+
+type GetSpaceListingsParams struct {
+	Name string `json:"name" validate:"required"`
+}
+
+type ResponseSellerListings struct {
+	Seller  string            `json:"seller"`
+	Valid   []ResponseListing `json:"valid"`
+	Invalid []ResponseListing `json:"invalid"`
+}
+
+type ResponseSpaceListings struct {
+	Space   string                   `json:"space"`
+	Sellers []ResponseSellerListings `json:"sellers"`
+}
+
+type GetSpaceHistoryParams struct {
+	Name     string `json:"name" validate:"required"`
+	Interval string `json:"interval" validate:"omitempty,oneof=hour day week month"`
+	Since    int64  `json:"since" validate:"omitempty,min=0"`
+	Limit    int    `json:"limit" validate:"omitempty,min=1,max=1000"`
+}
+
+type ResponsePricePoint struct {
+	Time  int64 `json:"time"`
+	Open  int64 `json:"open"`
+	High  int64 `json:"high"`
+	Low   int64 `json:"low"`
+	Close int64 `json:"close"`
+	Count int64 `json:"count"`
+}
+
+type ResponseSpaceHistory struct {
+	Space    string               `json:"space"`
+	Interval string               `json:"interval"`
+	Series   []ResponsePricePoint `json:"series"`
+}
+
+type GetSalesParams struct {
+	Limit  int `json:"limit" validate:"omitempty,min=1,max=100"`
+	Offset int `json:"offset" validate:"omitempty,min=0"`
+}
+
+type GetSpaceSalesParams struct {
+	Name   string `json:"name" validate:"required"`
+	Limit  int    `json:"limit" validate:"omitempty,min=1,max=100"`
+	Offset int    `json:"offset" validate:"omitempty,min=0"`
+}
+
+type ResponseSale struct {
 	Space     string `json:"space"`
 	Price     int    `json:"price"`
 	Seller    string `json:"seller"`
+	Buyer     string `json:"buyer"`
 	Signature string `json:"signature"`
-	Timestamp int64  `json:"timestamp"`
+	Txid      string `json:"txid"`
 	Height    int32  `json:"height"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+type GetSellerListingsParams struct {
+	Address    string `json:"address" validate:"required"`
+	Status     string `json:"status" validate:"omitempty,oneof=all valid invalid"`
+	Sort_by    string `json:"sort_by" validate:"omitempty,oneof=price timestamp"`
+	Sort_order string `json:"sort_order" validate:"omitempty,oneof=asc desc"`
+	Limit      int    `json:"limit" validate:"omitempty,min=1,max=100"`
+	Offset     int    `json:"offset" validate:"omitempty,min=0"`
+}
+
+type GetListingEventsParams struct {
+	Name   string `json:"name" validate:"required"`
+	Limit  int    `json:"limit" validate:"omitempty,min=1,max=100"`
+	Offset int    `json:"offset" validate:"omitempty,min=0"`
+}
+
+type ResponseListingEvent struct {
+	Space      string `json:"space"`
+	Signature  string `json:"signature"`
+	Event      string `json:"event"`
+	Height     int32  `json:"height"`
+	Txid       string `json:"txid,omitempty"`
+	SpaceEvent string `json:"space_event,omitempty"`
+	Reason     string `json:"reason,omitempty"`
+	Timestamp  int64  `json:"timestamp"`
+}
+
+type GetChangesParams struct {
+	Since string `json:"since" validate:"omitempty,max=512"`
+	Limit int    `json:"limit" validate:"omitempty,min=1,max=1000"`
+}
+
+type ResponseChange struct {
+	Seq        string `json:"seq"`
+	ID         int64  `json:"id"`
+	Event      string `json:"event"`
+	Space      string `json:"space"`
+	Signature  string `json:"signature"`
+	Price      int    `json:"price"`
+	Seller     string `json:"seller"`
+	Valid      bool   `json:"valid"`
+	Height     int32  `json:"height"`
+	Txid       string `json:"txid,omitempty"`
+	SpaceEvent string `json:"space_event,omitempty"`
+	Reason     string `json:"reason,omitempty"`
+	Timestamp  int64  `json:"timestamp"`
+	// the height and the creation time of the listing after the change
+	ListingHeight    int32 `json:"listing_height"`
+	ListingTimestamp int64 `json:"listing_timestamp"`
+}
+
+type ResponseChanges struct {
+	Changes []ResponseChange `json:"changes"`
+	Next    string           `json:"next"`
+}
+
+type ResponsePriceBucket struct {
+	Min   int64 `json:"min"`
+	Max   int64 `json:"max"`
+	Count int64 `json:"count"`
+}
+
+type ResponseLengthBucket struct {
+	Length int   `json:"length"`
+	Count  int64 `json:"count"`
+}
+
+type ResponseActivity struct {
+	New         int64 `json:"new"`
+	Invalidated int64 `json:"invalidated"`
+}
+
+type ResponseStats struct {
+	Height         int32                  `json:"height"`
+	ValidListings  int64                  `json:"valid_listings"`
+	ListedSpaces   int64                  `json:"listed_spaces"`
+	FloorPrice     int64                  `json:"floor_price"`
+	MedianPrice    float64                `json:"median_price"`
+	MeanPrice      float64                `json:"mean_price"`
+	PriceHistogram []ResponsePriceBucket  `json:"price_histogram"`
+	ByNameLength   []ResponseLengthBucket `json:"by_name_length"`
+	Last24h        ResponseActivity       `json:"last_24h"`
+	Last7d         ResponseActivity       `json:"last_7d"`
+	Last30d        ResponseActivity       `json:"last_30d"`
+}
+
+// ResponseSpaceState is the chain state of a listed space as last seen by
+// the indexer
+type ResponseSpaceState struct {
+	Outpoint         string `json:"outpoint,omitempty"`
+	LastUpdateHeight int32  `json:"last_update_height"`
+	ExpireHeight     int32  `json:"expire_height"`
+	ExpiringSoon     bool   `json:"expiring_soon"`
+	LastEvent        string `json:"last_event"`
+	PendingTxid      string `json:"pending_txid,omitempty"`
+}
+
+// expiringSoonBlocks is the number of blocks before its expiry from which a
+// listed space is flagged as expiring soon
+var expiringSoonBlocks int32 = 1008
+
+// Listing states of the responses, a pending listing is still valid but its
+// space is being transferred by a mempool transaction
+const (
+	ListingStateValid   = "valid"
+	ListingStatePending = "pending"
+	ListingStateInvalid = "invalid"
+)
+
+type ResponseListing struct {
+	Space     string              `json:"space"`
+	Price     int                 `json:"price"`
+	Seller    string              `json:"seller"`
+	Signature string              `json:"signature"`
+	Timestamp int64               `json:"timestamp"`
+	Height    int32               `json:"height"`
+	Valid     bool                `json:"valid"`
+	State     string              `json:"state"`
+	Relevance float32             `json:"relevance,omitempty"`
+	Chain     *ResponseSpaceState `json:"chain,omitempty"`
+}
+
+func newResponseListing(l db.Listing) ResponseListing {
+	listing := ResponseListing{
+		Space:     l.Name,
+		Price:     int(l.Price),
+		Seller:    l.Seller,
+		Signature: hex.EncodeToString(l.Signature),
+		Timestamp: l.Timestamp,
+		Height:    l.Height,
+		Valid:     l.Valid,
+		State:     ListingStateValid,
+	}
+	if !l.Valid {
+		listing.State = ListingStateInvalid
+	}
+	return listing
+}
+
+// setChain attaches the chain state of the space, a valid listing of a space
+// with a transfer in the mempool is pending
+func (l *ResponseListing) setChain(chain *ResponseSpaceState) {
+	l.Chain = chain
+	if l.Valid && chain != nil && chain.PendingTxid != "" {
+		l.State = ListingStatePending
+	}
+}
+
+// getSpaceStates returns the chain state of the spaces by name, spaces never
+// seen by the indexer are missing
+func getSpaceStates(ctx *Context, names []string) (map[string]*ResponseSpaceState, error) {
+	dbSpaces, err := ctx.DB.GetSpacesByNames(ctx, names)
+	if err != nil {
+		return nil, Internal("failed to get spaces", err)
+	}
+	tip, err := ctx.DB.GetLatestBlock(ctx)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return nil, Internal("failed to get spaces", err)
+	}
+	states := make(map[string]*ResponseSpaceState, len(dbSpaces))
+	for _, space := range dbSpaces {
+		state := &ResponseSpaceState{
+			LastUpdateHeight: space.LastUpdateHeight,
+			ExpireHeight:     space.ExpireHeight,
+			ExpiringSoon:     space.ExpireHeight > 0 && space.ExpireHeight-tip.Height <= expiringSoonBlocks,
+			LastEvent:        space.LastEvent,
+		}
+		if len(space.PendingTxid) > 0 {
+			state.PendingTxid = hex.EncodeToString(space.PendingTxid)
+		}
+		if len(space.OutpointTxid) > 0 {
+			state.Outpoint = fmt.Sprintf("%x:%d", space.OutpointTxid, space.OutpointN)
+		}
+		states[space.Name] = state
+	}
+	return states, nil
+}
+
+func listingNames(listings []db.Listing) []string {
+	names := make([]string, 0, len(listings))
+	for _, l := range listings {
+		names = append(names, l.Name)
+	}
+	return names
 }
 
 func getListingHandler(ctx *Context, params GetListingParams) (*ResponseListing, error) {
 	if params.Name == "" {
-		return nil, fmt.Errorf("name is required")
+		return nil, InvalidInput("name is required", ValidationError{Field: "name", Message: "This field is required"})
 	}
 
 	name := params.Name
@@ -42,27 +308,42 @@ func getListingHandler(ctx *Context, params GetListingParams) (*ResponseListing,
 
 	listings, err := ctx.DB.GetValidListingByName(ctx, name)
 	if err != nil {
-		log.Printf("failed to get listing: %s", err)
-		return nil, fmt.Errorf("failed to get listing")
+		return nil, Internal("failed to get listing", err)
 	}
 
 	if len(listings) == 0 {
-		return nil, fmt.Errorf("no listing found")
+		return nil, NotFound("no listing found")
+	}
+
+	states, err := getSpaceStates(ctx, []string{name})
+	if err != nil {
+		return nil, err
 	}
 
-	return &ResponseListing{
-		Space:     listings[0].Name,
-		Price:     int(listings[0].Price),
-		Seller:    listings[0].Seller,
-		Signature: hex.EncodeToString(listings[0].Signature),
-		Timestamp: listings[0].Timestamp,
-		Height:    listings[0].Height,
-	}, nil
+	listing := newResponseListing(listings[0])
+	listing.setChain(states[name])
+	return &listing, nil
+}
+
+// optionalInt returns the value of an optional filter, or -1 if the filter
+// is not set, which the listings query treats as no bound
+func optionalInt[T int | int64](v *T) int64 {
+	if v == nil {
+		return -1
+	}
+	return int64(*v)
 }
 
-func getListingsHandler(ctx *Context, params GetListingsParams) ([]ResponseListing, error) {
+func getListingsHandler(ctx *Context, params GetListingsParams) (*ListingsPage, error) {
+	params.Search = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(params.Search), "@"))
+	if params.Search_mode == "" {
+		params.Search_mode = "any" // default search mode
+	}
 	if params.Sort_by == "" {
 		params.Sort_by = "timestamp" // default sort field
+		if params.Search != "" {
+			params.Sort_by = "relevance"
+		}
 	}
 	if params.Sort_order == "" {
 		params.Sort_order = "desc" // default sort order
@@ -72,39 +353,469 @@ func getListingsHandler(ctx *Context, params GetListingsParams) ([]ResponseListi
 	}
 
 	if err := ctx.Validator.Struct(params); err != nil {
-		return nil, fmt.Errorf("invalid parameters: %w", err)
+		return nil, validationError(err)
+	}
+
+	var cursor listingsCursor
+	if params.Cursor != "" {
+		var err error
+		cursor, err = decodeListingsCursor(params.Cursor)
+		if err != nil {
+			return nil, InvalidInput(err.Error(), ValidationError{Field: "cursor", Message: err.Error()})
+		}
+		if cursor.SortBy != params.Sort_by || cursor.SortOrder != params.Sort_order {
+			return nil, InvalidInput("invalid cursor", ValidationError{Field: "cursor", Message: "Sort does not match the request"})
+		}
+		params.Offset = 0
 	}
 
+	countParams := db.CountLatestListingsParams{
+		Search:     params.Search,
+		SearchMode: params.Search_mode,
+		SearchLike: likeEscaper.Replace(params.Search),
+		MinPrice:   optionalInt(params.Min_price),
+		MaxPrice:   optionalInt(params.Max_price),
+		MinLength:  int32(optionalInt(params.Min_length)),
+		MaxLength:  int32(optionalInt(params.Max_length)),
+		Charset:    params.Charset,
+		Seller:     params.Seller,
+		MinHeight:  int32(optionalInt(params.Min_height)),
+		MaxHeight:  int32(optionalInt(params.Max_height)),
+	}
 	dbParams := db.GetLatestListingsParams{
-		SortBy:    params.Sort_by,
-		SortOrder: params.Sort_order,
-		Limit:     int32(params.Limit),
-		Offset:    int32(params.Offset),
+		Search:     countParams.Search,
+		SearchMode: countParams.SearchMode,
+		SearchLike: countParams.SearchLike,
+		MinPrice:   countParams.MinPrice,
+		MaxPrice:   countParams.MaxPrice,
+		MinLength:  countParams.MinLength,
+		MaxLength:  countParams.MaxLength,
+		Charset:    countParams.Charset,
+		Seller:     countParams.Seller,
+		MinHeight:  countParams.MinHeight,
+		MaxHeight:  countParams.MaxHeight,
+		AfterName:  cursor.Name,
+		AfterKey:   cursor.Key,
+		SortBy:     params.Sort_by,
+		SortOrder:  params.Sort_order,
+		Limit:      int32(params.Limit),
+		Offset:     int32(params.Offset),
+	}
+
+	total, err := ctx.DB.CountLatestListings(ctx, countParams)
+	if err != nil {
+		return nil, Internal("failed to get listings", err)
 	}
 
 	dbListings, err := ctx.DB.GetLatestListings(ctx, dbParams)
 	if err != nil {
-		log.Printf("failed to get listings: %s", err)
-		return nil, fmt.Errorf("failed to get listings")
+		return nil, Internal("failed to get listings", err)
 	}
-	if len(dbListings) == 0 {
-		return []ResponseListing{}, nil
+
+	names := make([]string, 0, len(dbListings))
+	for _, l := range dbListings {
+		names = append(names, l.Name)
+	}
+	states, err := getSpaceStates(ctx, names)
+	if err != nil {
+		return nil, err
 	}
 
-	listings := make([]ResponseListing, 0, len(dbListings))
+	page := &ListingsPage{Listings: make([]ResponseListing, 0, len(dbListings)), Total: total}
 	for _, l := range dbListings {
-		listings = append(listings, ResponseListing{
+		listing := ResponseListing{
 			Space:     l.Name,
 			Price:     int(l.Price),
 			Seller:    l.Seller,
 			Signature: hex.EncodeToString(l.Signature),
 			Timestamp: l.Timestamp,
 			Height:    l.Height,
+			Valid:     true,
+			State:     ListingStateValid,
+			Relevance: l.Relevance,
+		}
+		listing.setChain(states[l.Name])
+		page.Listings = append(page.Listings, listing)
+	}
+
+	page.NextCursor = nextListingsCursor(params.Sort_by, params.Sort_order, params.Limit, dbListings)
+	return page, nil
+}
+
+// getSpacesHandler looks up the best valid listing of many spaces at once,
+// results are returned in the order of the requested names
+func getSpacesHandler(ctx *Context, params GetSpacesParams) ([]ResponseSpaceLookup, error) {
+	names := make([]string, 0, len(params.Names))
+	for _, name := range params.Names {
+		if len(name) > 0 && name[0] == '@' {
+			name = name[1:]
+		}
+		names = append(names, name)
+	}
+
+	dbListings, err := ctx.DB.GetValidListingsByNames(ctx, names)
+	if err != nil {
+		return nil, Internal("failed to get listings", err)
+	}
+
+	states, err := getSpaceStates(ctx, names)
+	if err != nil {
+		return nil, err
+	}
+
+	found := make(map[string]db.Listing, len(dbListings))
+	for _, l := range dbListings {
+		found[l.Name] = l
+	}
+
+	result := make([]ResponseSpaceLookup, 0, len(names))
+	for _, name := range names {
+		lookup := ResponseSpaceLookup{Space: name}
+		if l, ok := found[name]; ok {
+			listing := newResponseListing(l)
+			listing.setChain(states[name])
+			lookup.Found = true
+			lookup.Listing = &listing
+		}
+		result = append(result, lookup)
+	}
+	return result, nil
+}
+
+// getSpaceListingsHandler returns the order book of a space: every valid and
+// historic listing grouped by seller, sellers ordered by their cheapest offer
+func getSpaceListingsHandler(ctx *Context, params GetSpaceListingsParams) (*ResponseSpaceListings, error) {
+	name := params.Name
+	if len(name) > 0 && name[0] == '@' {
+		name = name[1:]
+	}
+
+	dbListings, err := ctx.DB.GetListingByName(ctx, name)
+	if err != nil {
+		return nil, Internal("failed to get listings", err)
+	}
+
+	if len(dbListings) == 0 {
+		return nil, NotFound("no listing found")
+	}
+
+	states, err := getSpaceStates(ctx, []string{name})
+	if err != nil {
+		return nil, err
+	}
+
+	result := &ResponseSpaceListings{Space: name, Sellers: []ResponseSellerListings{}}
+	sellers := map[string]int{}
+	for _, l := range dbListings {
+		i, ok := sellers[l.Seller]
+		if !ok {
+			i = len(result.Sellers)
+			sellers[l.Seller] = i
+			result.Sellers = append(result.Sellers, ResponseSellerListings{
+				Seller:  l.Seller,
+				Valid:   []ResponseListing{},
+				Invalid: []ResponseListing{},
+			})
+		}
+		listing := newResponseListing(l)
+		listing.setChain(states[name])
+		if l.Valid {
+			result.Sellers[i].Valid = append(result.Sellers[i].Valid, listing)
+		} else {
+			result.Sellers[i].Invalid = append(result.Sellers[i].Invalid, listing)
+		}
+	}
+	return result, nil
+}
+
+func getSpaceHistoryHandler(ctx *Context, params GetSpaceHistoryParams) (*ResponseSpaceHistory, error) {
+	name := params.Name
+	if len(name) > 0 && name[0] == '@' {
+		name = name[1:]
+	}
+	if params.Interval == "" {
+		params.Interval = "day"
+	}
+	if params.Limit <= 0 {
+		params.Limit = 100 // default limit
+	}
+
+	buckets, err := ctx.DB.GetPriceHistoryBuckets(ctx, db.GetPriceHistoryBucketsParams{
+		Interval: params.Interval,
+		Name:     name,
+		Since:    params.Since,
+		Limit:    int32(params.Limit),
+	})
+	if err != nil {
+		return nil, Internal("failed to get price history", err)
+	}
+
+	// the latest buckets are selected, the series is returned oldest first
+	result := &ResponseSpaceHistory{Space: name, Interval: params.Interval, Series: make([]ResponsePricePoint, len(buckets))}
+	for i, b := range buckets {
+		result.Series[len(buckets)-1-i] = ResponsePricePoint{
+			Time:  b.Bucket,
+			Open:  b.Open,
+			High:  b.High,
+			Low:   b.Low,
+			Close: b.Close,
+			Count: b.Count,
+		}
+	}
+	return result, nil
+}
+
+func newResponseSales(dbSales []db.Sale) []ResponseSale {
+	sales := make([]ResponseSale, 0, len(dbSales))
+	for _, sale := range dbSales {
+		sales = append(sales, ResponseSale{
+			Space:     sale.Name,
+			Price:     int(sale.Price),
+			Seller:    sale.Seller,
+			Buyer:     sale.Buyer,
+			Signature: hex.EncodeToString(sale.Signature),
+			Txid:      hex.EncodeToString(sale.Txid),
+			Height:    sale.Height,
+			Timestamp: sale.Timestamp,
 		})
 	}
+	return sales
+}
+
+func getSalesHandler(ctx *Context, params GetSalesParams) ([]ResponseSale, error) {
+	if params.Limit <= 0 {
+		params.Limit = 20 // default limit
+	}
+
+	dbSales, err := ctx.DB.GetSales(ctx, db.GetSalesParams{
+		Limit:  int32(params.Limit),
+		Offset: int32(params.Offset),
+	})
+	if err != nil {
+		return nil, Internal("failed to get sales", err)
+	}
+	return newResponseSales(dbSales), nil
+}
+
+func getSpaceSalesHandler(ctx *Context, params GetSpaceSalesParams) ([]ResponseSale, error) {
+	name := params.Name
+	if len(name) > 0 && name[0] == '@' {
+		name = name[1:]
+	}
+	if params.Limit <= 0 {
+		params.Limit = 20 // default limit
+	}
+
+	dbSales, err := ctx.DB.GetSalesByName(ctx, db.GetSalesByNameParams{
+		Name:   name,
+		Limit:  int32(params.Limit),
+		Offset: int32(params.Offset),
+	})
+	if err != nil {
+		return nil, Internal("failed to get sales", err)
+	}
+	return newResponseSales(dbSales), nil
+}
+
+func getSellerListingsHandler(ctx *Context, params GetSellerListingsParams) ([]ResponseListing, error) {
+	if params.Status == "" {
+		params.Status = "all"
+	}
+	if params.Sort_by == "" {
+		params.Sort_by = "timestamp" // default sort field
+	}
+	if params.Sort_order == "" {
+		params.Sort_order = "desc" // default sort order
+	}
+	if params.Limit <= 0 {
+		params.Limit = 20 // default limit
+	}
+
+	dbListings, err := ctx.DB.GetListingsBySeller(ctx, db.GetListingsBySellerParams{
+		Seller:    params.Address,
+		Status:    params.Status,
+		SortBy:    params.Sort_by,
+		SortOrder: params.Sort_order,
+		Limit:     int32(params.Limit),
+		Offset:    int32(params.Offset),
+	})
+	if err != nil {
+		return nil, Internal("failed to get seller listings", err)
+	}
+
+	states, err := getSpaceStates(ctx, listingNames(dbListings))
+	if err != nil {
+		return nil, err
+	}
+
+	listings := make([]ResponseListing, 0, len(dbListings))
+	for _, l := range dbListings {
+		listing := newResponseListing(l)
+		listing.setChain(states[l.Name])
+		listings = append(listings, listing)
+	}
 	return listings, nil
 }
 
+func getListingEventsHandler(ctx *Context, params GetListingEventsParams) ([]ResponseListingEvent, error) {
+	name := params.Name
+	if len(name) > 0 && name[0] == '@' {
+		name = name[1:]
+	}
+	if params.Limit <= 0 {
+		params.Limit = 20 // default limit
+	}
+
+	dbEvents, err := ctx.DB.GetListingEventsByName(ctx, db.GetListingEventsByNameParams{
+		Name:   name,
+		Limit:  int32(params.Limit),
+		Offset: int32(params.Offset),
+	})
+	if err != nil {
+		return nil, Internal("failed to get listing events", err)
+	}
+
+	events := make([]ResponseListingEvent, 0, len(dbEvents))
+	for _, e := range dbEvents {
+		events = append(events, ResponseListingEvent{
+			Space:      e.Name,
+			Signature:  hex.EncodeToString(e.Signature),
+			Event:      e.Event,
+			Height:     e.Height,
+			Txid:       hex.EncodeToString(e.Txid),
+			SpaceEvent: e.SpaceEvent,
+			Reason:     e.Reason,
+			Timestamp:  e.Timestamp,
+		})
+	}
+	return events, nil
+}
+
+// getChangesHandler returns the listing changes after the since cursor in
+// feed order. Each change carries the state of the listing right after it,
+// so replaying the feed in order yields an exact replica of the listings.
+// Next is the cursor to poll with, it stays the same when there is nothing new.
+func getChangesHandler(ctx *Context, params GetChangesParams) (*ResponseChanges, error) {
+	if params.Limit <= 0 {
+		params.Limit = 100 // default limit
+	}
+
+	var since changesCursor
+	if params.Since != "" {
+		var err error
+		since, err = decodeChangesCursor(params.Since)
+		if err != nil {
+			return nil, InvalidInput(err.Error(), ValidationError{Field: "since", Message: err.Error()})
+		}
+	}
+
+	dbChanges, err := ctx.DB.GetListingChanges(ctx, db.GetListingChangesParams{
+		AfterXactID: since.XactID,
+		AfterID:     since.ID,
+		Limit:       int32(params.Limit),
+	})
+	if err != nil {
+		return nil, Internal("failed to get changes", err)
+	}
+
+	result := &ResponseChanges{Changes: make([]ResponseChange, 0, len(dbChanges)), Next: since.encode()}
+	for _, c := range dbChanges {
+		seq := changesCursor{XactID: c.XactID, ID: c.ID}.encode()
+		result.Changes = append(result.Changes, ResponseChange{
+			Seq:        seq,
+			ID:         c.ID,
+			Event:      c.Event,
+			Space:      c.Name,
+			Signature:  hex.EncodeToString(c.Signature),
+			Price:      int(c.Price),
+			Seller:     c.Seller,
+			Valid:      c.Valid,
+			Height:     c.Height,
+			Txid:       hex.EncodeToString(c.Txid),
+			SpaceEvent: c.SpaceEvent,
+			Reason:     c.Reason,
+			Timestamp:  c.Timestamp,
+
+			ListingHeight:    c.ListingHeight,
+			ListingTimestamp: c.ListingTimestamp,
+		})
+		result.Next = seq
+	}
+	return result, nil
+}
+
+// blocksPerDay is the expected number of blocks mined per day, invalidations
+// are recorded at block heights so their windows are counted in blocks
+const blocksPerDay = 144
+
+func getStatsHandler(ctx *Context, _ struct{}) (*ResponseStats, error) {
+	tip, err := ctx.DB.GetLatestBlock(ctx)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return nil, Internal("failed to get stats", err)
+	}
+
+	listings, err := ctx.DB.GetListingStats(ctx)
+	if err != nil {
+		return nil, Internal("failed to get stats", err)
+	}
+	histogram, err := ctx.DB.GetPriceHistogram(ctx)
+	if err != nil {
+		return nil, Internal("failed to get stats", err)
+	}
+	lengths, err := ctx.DB.GetListingsByNameLength(ctx)
+	if err != nil {
+		return nil, Internal("failed to get stats", err)
+	}
+
+	now := time.Now()
+	day := 24 * time.Hour
+	activity, err := ctx.DB.GetActivityStats(ctx, db.GetActivityStatsParams{
+		SinceDay:    now.Add(-day).Unix(),
+		SinceWeek:   now.Add(-7 * day).Unix(),
+		SinceMonth:  now.Add(-30 * day).Unix(),
+		HeightDay:   tip.Height - blocksPerDay + 1,
+		HeightWeek:  tip.Height - 7*blocksPerDay + 1,
+		HeightMonth: tip.Height - 30*blocksPerDay + 1,
+	})
+	if err != nil {
+		return nil, Internal("failed to get stats", err)
+	}
+
+	result := &ResponseStats{
+		Height:         tip.Height,
+		ValidListings:  listings.ValidListings,
+		ListedSpaces:   listings.ListedSpaces,
+		FloorPrice:     listings.FloorPrice,
+		MedianPrice:    listings.MedianPrice,
+		MeanPrice:      listings.MeanPrice,
+		PriceHistogram: make([]ResponsePriceBucket, 0, len(histogram)),
+		ByNameLength:   make([]ResponseLengthBucket, 0, len(lengths)),
+		Last24h:        ResponseActivity{New: activity.NewDay, Invalidated: activity.InvalidatedDay},
+		Last7d:         ResponseActivity{New: activity.NewWeek, Invalidated: activity.InvalidatedWeek},
+		Last30d:        ResponseActivity{New: activity.NewMonth, Invalidated: activity.InvalidatedMonth},
+	}
+	// buckets are powers of ten of the price, the first one includes zero
+	for _, b := range histogram {
+		bucket := ResponsePriceBucket{Min: pow10(b.Magnitude), Max: pow10(b.Magnitude+1) - 1, Count: b.Count}
+		if b.Magnitude == 0 {
+			bucket.Min = 0
+		}
+		result.PriceHistogram = append(result.PriceHistogram, bucket)
+	}
+	for _, l := range lengths {
+		result.ByNameLength = append(result.ByNameLength, ResponseLengthBucket{Length: int(l.Length), Count: l.Count})
+	}
+	return result, nil
+}
+
+func pow10(n int32) int64 {
+	v := int64(1)
+	for ; n > 0; n-- {
+		v *= 10
+	}
+	return v
+}
+
 type HealthCheckResult = struct {
 	Height       int32  `json:"height"`
 	Hash         string `json:"hash"`
@@ -115,13 +826,13 @@ type HealthCheckResult = struct {
 func healthCheckHandler(ctx *Context, _ struct{}) (*HealthCheckResult, error) {
 	res, err := ctx.DB.GetLatestBlock(ctx)
 	if err != nil {
-		log.Printf("failed to perform a healthcheck: %s", err)
-		return nil, fmt.Errorf("failed to perform a healthcheck")
+		return nil, Internal("failed to perform a healthcheck", err)
 	}
-	serverInfo, err := ctx.Spaces.GetServerInfo(ctx)
+	infoCtx, cancel := context.WithTimeout(ctx, store.RPCTimeout)
+	defer cancel()
+	serverInfo, err := ctx.Spaces.GetServerInfo(infoCtx)
 	if err != nil {
-		log.Printf("failed to perform a healthcheck: %s", err)
-		return nil, fmt.Errorf("failed to perform a healthcheck")
+		return nil, UpstreamUnavailable(err)
 	}
 	toReturn := &HealthCheckResult{
 		Height:       res.Height,
@@ -134,24 +845,17 @@ func healthCheckHandler(ctx *Context, _ struct{}) (*HealthCheckResult, error) {
 
 func postListingHandler(ctx *Context, listing node.Listing) (*node.Listing, error) {
 	if listing.Space == "" || listing.Price < 0 || listing.Seller == "" || listing.Signature == "" {
-		return nil, fmt.Errorf("missing required fields")
+		return nil, InvalidInput("missing required fields")
 	}
 
 	listing.NormalizeSpace()
 	if err := ctx.Spaces.VerifyListing(ctx, listing); err != nil {
-		if len(err.Error()) <= 12 {
-			return nil, err
-		}
-		if err.Error()[:12] == "rpc client: " {
-			return nil, fmt.Errorf(err.Error()[12:])
-		}
-		log.Printf("got an error while trying to verify: %s", err)
-		return nil, fmt.Errorf("An error occured")
+		return nil, verifyError(err)
 	}
 
 	signatureBytes, err := hex.DecodeString(listing.Signature)
 	if err != nil {
-		return nil, fmt.Errorf("invalid signature format: %s", err)
+		return nil, InvalidInput("invalid signature format", ValidationError{Field: "signature", Message: err.Error()})
 	}
 
 	spaceName := listing.Space
@@ -159,16 +863,73 @@ func postListingHandler(ctx *Context, listing node.Listing) (*node.Listing, erro
 		spaceName = spaceName[1:]
 	}
 
+	existing, err := ctx.DB.GetListingBySignature(ctx, signatureBytes)
+	if err != nil {
+		return nil, Internal("failed to create listing", err)
+	}
+
+	tip, err := ctx.DB.GetLatestBlock(ctx)
+	if err != nil {
+		return nil, Internal("failed to create listing", err)
+	}
+
+	// the listing has just been verified, the revalidation sweep skips it
+	// until it is due
 	err = ctx.DB.UpsertListing(ctx, db.UpsertListingParams{
-		Name:      spaceName,
-		Price:     int64(listing.Price),
-		Seller:    listing.Seller,
-		Signature: signatureBytes,
-		Valid:     true,
+		Name:           spaceName,
+		Price:          int64(listing.Price),
+		Seller:         listing.Seller,
+		Signature:      signatureBytes,
+		Valid:          true,
+		LastVerifiedAt: time.Now().Unix(),
 	})
 	if err != nil {
-		log.Printf("failed to create listing: %s", err)
-		return nil, fmt.Errorf("failed to create listing")
+		return nil, dbWriteError("failed to create listing", err)
+	}
+
+	recordEvent := func(signature []byte, event, reason string) error {
+		return ctx.DB.InsertListingEvent(ctx, db.InsertListingEventParams{
+			Signature: signature,
+			Name:      spaceName,
+			Event:     event,
+			Height:    tip.Height,
+			Reason:    reason,
+		})
+	}
+
+	// a repost of a stored listing only changes it when it was invalid
+	event := store.EventCreated
+	if len(existing) > 0 {
+		if existing[0].Valid {
+			return &listing, nil
+		}
+		event = store.EventRevalidated
+	}
+	if err := recordEvent(signatureBytes, event, ""); err != nil {
+		return nil, Internal("failed to create listing", err)
+	}
+
+	// the listing replaces the other listings of the seller for the space,
+	// they stay invalid until they are posted again
+	others, err := ctx.DB.GetListingByName(ctx, spaceName)
+	if err != nil {
+		return nil, Internal("failed to create listing", err)
+	}
+	for _, other := range others {
+		if !other.Valid || other.Seller != listing.Seller || bytes.Equal(other.Signature, signatureBytes) {
+			continue
+		}
+		_, err := ctx.DB.UpdateListingValidityAndHeight(ctx, db.UpdateListingValidityAndHeightParams{
+			Signature: other.Signature,
+			Valid:     false,
+			Height:    tip.Height,
+		})
+		if err != nil {
+			return nil, dbWriteError("failed to create listing", err)
+		}
+		if err := recordEvent(other.Signature, store.EventSuperseded, "replaced by a newer listing of the seller"); err != nil {
+			return nil, Internal("failed to create listing", err)
+		}
 	}
 
 	return &listing, nil
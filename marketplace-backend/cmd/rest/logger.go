@@ -3,13 +3,14 @@ package main
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"io"
 	"net/http"
 	"time"
 
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/rs/zerolog/log"
-	"github.com/spacesprotocol/explorer-indexer/pkg/node"
+	"github.com/spacesprotocol/marketplace/pkg/store"
 )
 
 func withLogging(handler http.HandlerFunc) http.HandlerFunc {
@@ -21,9 +22,13 @@ func withLogging(handler http.HandlerFunc) http.HandlerFunc {
 		if r.Body != nil {
 			var err error
 			bodyBytes, r.Body, err = readBody(r.Body)
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				writeError(w, PayloadTooLarge())
+				return
+			}
 			if err != nil {
-				log.Error().Err(err).Msg("Failed to read request body")
-				http.Error(w, "Failed to read request body", http.StatusInternalServerError)
+				writeError(w, Internal("failed to read request body", err))
 				return
 			}
 		}
@@ -80,6 +85,13 @@ func (rw *responseWriter) Write(b []byte) (int, error) {
 	return rw.ResponseWriter.Write(b)
 }
 
+// Flush lets streaming handlers flush through the wrapper
+func (rw *responseWriter) Flush() {
+	if flusher, ok := rw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
 // Helper to read body and allow it to be read again
 func readBody(r io.ReadCloser) ([]byte, io.ReadCloser, error) {
 	body, err := io.ReadAll(r)
@@ -90,6 +102,11 @@ func readBody(r io.ReadCloser) ([]byte, io.ReadCloser, error) {
 }
 
 // Extend the Action struct with a method to build a logged handler
-func (a *Action) BuildLoggedHandler(tx *pgxpool.Pool, spacesClient node.SpacesClient) http.HandlerFunc {
+func (a *Action) BuildLoggedHandler(tx *pgxpool.Pool, spacesClient *store.SpacesClient) http.HandlerFunc {
 	return withLogging(a.BuildHandler(tx, spacesClient))
 }
+
+// BuildLoggedHandler builds a logged handler serving all the routes
+func (routes Routes) BuildLoggedHandler(tx *pgxpool.Pool, spacesClient *store.SpacesClient) http.HandlerFunc {
+	return withLogging(routes.BuildHandler(tx, spacesClient))
+}
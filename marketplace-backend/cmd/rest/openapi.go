@@ -0,0 +1,324 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// Schema is an OpenAPI 3 schema object
+type Schema struct {
+	Ref         string             `json:"$ref,omitempty"`
+	Type        string             `json:"type,omitempty"`
+	Format      string             `json:"format,omitempty"`
+	Nullable    bool               `json:"nullable,omitempty"`
+	Enum        []interface{}      `json:"enum,omitempty"`
+	Minimum     *float64           `json:"minimum,omitempty"`
+	Maximum     *float64           `json:"maximum,omitempty"`
+	MinLength   *int               `json:"minLength,omitempty"`
+	MaxLength   *int               `json:"maxLength,omitempty"`
+	MinItems    *int               `json:"minItems,omitempty"`
+	MaxItems    *int               `json:"maxItems,omitempty"`
+	Items       *Schema            `json:"items,omitempty"`
+	Properties  map[string]*Schema `json:"properties,omitempty"`
+	Required    []string           `json:"required,omitempty"`
+	Description string             `json:"description,omitempty"`
+}
+
+type Parameter struct {
+	Name     string  `json:"name"`
+	In       string  `json:"in"`
+	Required bool    `json:"required,omitempty"`
+	Schema   *Schema `json:"schema"`
+}
+
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+type RequestBody struct {
+	Required bool                 `json:"required"`
+	Content  map[string]MediaType `json:"content"`
+}
+
+type Response struct {
+	Description string               `json:"description"`
+	Content     map[string]MediaType `json:"content,omitempty"`
+}
+
+type Operation struct {
+	OperationID string              `json:"operationId"`
+	Parameters  []Parameter         `json:"parameters,omitempty"`
+	RequestBody *RequestBody        `json:"requestBody,omitempty"`
+	Responses   map[string]Response `json:"responses"`
+}
+
+// OpenAPI is an OpenAPI 3 document generated from the registered actions
+type OpenAPI struct {
+	OpenAPI    string                          `json:"openapi"`
+	Info       map[string]string               `json:"info"`
+	Paths      map[string]map[string]Operation `json:"paths"`
+	Components struct {
+		Schemas map[string]*Schema `json:"schemas"`
+	} `json:"components"`
+}
+
+// jsonTyper is implemented by results whose JSON encoding is described by
+// another type than their own, e.g. because of a custom MarshalJSON
+type jsonTyper interface {
+	JSONType() reflect.Type
+}
+
+// NewOpenAPI generates the OpenAPI document of the routes. Params of GET
+// actions become path and query parameters, params of POST actions the
+// request body, validator tags are translated into schema constraints.
+func NewOpenAPI(routes Routes) *OpenAPI {
+	spec := &OpenAPI{
+		OpenAPI: "3.0.3",
+		Info:    map[string]string{"title": "Spaces marketplace", "version": "1.0.0"},
+		Paths:   map[string]map[string]Operation{},
+	}
+	spec.Components.Schemas = map[string]*Schema{}
+
+	for _, action := range routes {
+		op := Operation{
+			OperationID: operationID(action),
+			Responses: map[string]Response{
+				"200": {
+					Description: "OK",
+					Content:     map[string]MediaType{"application/json": {Schema: spec.schemaOf(resultType(action.Result))}},
+				},
+				"default": {
+					Description: "Error",
+					Content:     map[string]MediaType{"application/json": {Schema: spec.errorSchema()}},
+				},
+			},
+		}
+
+		if action.Method == http.MethodPost || action.Method == http.MethodPut {
+			op.RequestBody = &RequestBody{
+				Required: true,
+				Content:  map[string]MediaType{"application/json": {Schema: spec.schemaOf(action.Params)}},
+			}
+		} else {
+			op.Parameters = spec.parametersOf(action)
+		}
+
+		if spec.Paths[action.Path] == nil {
+			spec.Paths[action.Path] = map[string]Operation{}
+		}
+		spec.Paths[action.Path][strings.ToLower(action.Method)] = op
+	}
+	return spec
+}
+
+// Handler serves the document as JSON
+func (spec *OpenAPI) Handler() http.HandlerFunc {
+	data, err := json.Marshal(spec)
+	if err != nil {
+		log.Fatalf("failed to encode openapi document: %v", err)
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeError(w, MethodNotAllowed(r.Method))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(data)
+	}
+}
+
+// operationID derives the operation id from the handler function name
+func operationID(action *Action) string {
+	name := ""
+	if fn := runtime.FuncForPC(action.Function.Pointer()); fn != nil {
+		name = fn.Name()[strings.LastIndex(fn.Name(), ".")+1:]
+	}
+	name = strings.TrimSuffix(name, "Handler")
+	if name == "" {
+		return strings.ToLower(action.Method) + strings.ReplaceAll(action.Path, "/", "_")
+	}
+	return name
+}
+
+func resultType(t reflect.Type) reflect.Type {
+	if t.Implements(reflect.TypeOf((*jsonTyper)(nil)).Elem()) {
+		return reflect.Zero(t).Interface().(jsonTyper).JSONType()
+	}
+	return t
+}
+
+func (spec *OpenAPI) errorSchema() *Schema {
+	return spec.schemaOf(reflect.TypeOf(ErrorResponse{}))
+}
+
+func (spec *OpenAPI) parametersOf(action *Action) []Parameter {
+	t := action.Params
+	if t.Kind() != reflect.Struct {
+		return nil
+	}
+	var params []Parameter
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, ok := jsonName(field)
+		if !ok {
+			continue
+		}
+		schema, required := spec.fieldSchema(field)
+		param := Parameter{Name: name, In: "query", Required: required, Schema: schema}
+		if strings.Contains(action.Path, "{"+strings.ToLower(field.Name)+"}") {
+			param.In = "path"
+			param.Required = true
+		}
+		params = append(params, param)
+	}
+	return params
+}
+
+// schemaOf returns the schema of a type, named structs are added to the
+// components and referenced
+func (spec *OpenAPI) schemaOf(t reflect.Type) *Schema {
+	switch t.Kind() {
+	case reflect.Ptr:
+		return spec.schemaOf(t.Elem())
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Uint:
+		return &Schema{Type: "integer"}
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return &Schema{Type: "integer", Format: "int32"}
+	case reflect.Int64, reflect.Uint64:
+		return &Schema{Type: "integer", Format: "int64"}
+	case reflect.Float32:
+		return &Schema{Type: "number", Format: "float"}
+	case reflect.Float64:
+		return &Schema{Type: "number", Format: "double"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string", Format: "byte"}
+		}
+		return &Schema{Type: "array", Items: spec.schemaOf(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object"}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return spec.structSchema(t)
+		}
+		name := t.Name()
+		if _, ok := spec.Components.Schemas[name]; !ok {
+			// register first so recursive types terminate
+			spec.Components.Schemas[name] = &Schema{}
+			*spec.Components.Schemas[name] = *spec.structSchema(t)
+		}
+		return &Schema{Ref: "#/components/schemas/" + name}
+	}
+	return &Schema{}
+}
+
+func (spec *OpenAPI) structSchema(t reflect.Type) *Schema {
+	schema := &Schema{Type: "object", Properties: map[string]*Schema{}}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, ok := jsonName(field)
+		if !ok {
+			continue
+		}
+		fieldSchema, required := spec.fieldSchema(field)
+		schema.Properties[name] = fieldSchema
+		if required {
+			schema.Required = append(schema.Required, name)
+		}
+	}
+	return schema
+}
+
+// fieldSchema returns the schema of a struct field with the constraints of
+// its validate tag applied, and whether the field is required
+func (spec *OpenAPI) fieldSchema(field reflect.StructField) (*Schema, bool) {
+	schema := spec.schemaOf(field.Type)
+	if field.Type.Kind() == reflect.Ptr {
+		schema.Nullable = schema.Ref == ""
+	}
+
+	tag := field.Tag.Get("validate")
+	if tag == "" {
+		return schema, false
+	}
+	required := false
+	target := schema
+	for _, rule := range strings.Split(tag, ",") {
+		key, value, _ := strings.Cut(rule, "=")
+		switch key {
+		case "required":
+			if target == schema {
+				required = true
+			}
+		case "dive":
+			if target.Items == nil {
+				return schema, required
+			}
+			target = target.Items
+		case "oneof":
+			for _, v := range strings.Fields(value) {
+				target.Enum = append(target.Enum, v)
+			}
+		case "min", "max":
+			applyBound(target, key, value)
+		}
+	}
+	return schema, required
+}
+
+// applyBound translates a min or max rule into the constraint matching the
+// schema type, like the validator does for the Go type
+func applyBound(schema *Schema, key, value string) {
+	switch schema.Type {
+	case "integer", "number":
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return
+		}
+		if key == "min" {
+			schema.Minimum = &v
+		} else {
+			schema.Maximum = &v
+		}
+	case "string", "array":
+		v, err := strconv.Atoi(value)
+		if err != nil {
+			return
+		}
+		switch {
+		case schema.Type == "string" && key == "min":
+			schema.MinLength = &v
+		case schema.Type == "string":
+			schema.MaxLength = &v
+		case key == "min":
+			schema.MinItems = &v
+		default:
+			schema.MaxItems = &v
+		}
+	}
+}
+
+// jsonName returns the JSON name of a field, false if it is not encoded
+func jsonName(field reflect.StructField) (string, bool) {
+	if !field.IsExported() {
+		return "", false
+	}
+	tag := field.Tag.Get("json")
+	if tag == "-" {
+		return "", false
+	}
+	name, _, _ := strings.Cut(tag, ",")
+	if name == "" {
+		name = field.Name
+	}
+	return name, true
+}
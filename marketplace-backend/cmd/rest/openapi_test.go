@@ -0,0 +1,50 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFieldSchema(t *testing.T) {
+	type params struct {
+		Required string   `json:"required" validate:"required"`
+		Sort     string   `json:"sort" validate:"omitempty,oneof=price timestamp"`
+		Limit    int      `json:"limit" validate:"omitempty,min=1,max=100"`
+		Price    *float64 `json:"price" validate:"omitempty,min=0"`
+		Name     string   `json:"name" validate:"min=3,max=63"`
+		Names    []string `json:"names" validate:"required,min=1,max=10,dive,max=63"`
+		Untagged int32    `json:"untagged"`
+		BadBound int      `json:"bad_bound" validate:"min=one"`
+	}
+	fval := func(v float64) *float64 { return &v }
+	ival := func(v int) *int { return &v }
+
+	tests := []struct {
+		field    string
+		want     Schema
+		required bool
+	}{
+		{field: "Required", want: Schema{Type: "string"}, required: true},
+		{field: "Sort", want: Schema{Type: "string", Enum: []interface{}{"price", "timestamp"}}},
+		{field: "Limit", want: Schema{Type: "integer", Minimum: fval(1), Maximum: fval(100)}},
+		{field: "Price", want: Schema{Type: "number", Format: "double", Nullable: true, Minimum: fval(0)}},
+		{field: "Name", want: Schema{Type: "string", MinLength: ival(3), MaxLength: ival(63)}},
+		{field: "Names", want: Schema{Type: "array", MinItems: ival(1), MaxItems: ival(10), Items: &Schema{Type: "string", MaxLength: ival(63)}}, required: true},
+		{field: "Untagged", want: Schema{Type: "integer", Format: "int32"}},
+		{field: "BadBound", want: Schema{Type: "integer"}},
+	}
+	spec := NewOpenAPI(Routes{})
+	typ := reflect.TypeOf(params{})
+	for _, tt := range tests {
+		t.Run(tt.field, func(t *testing.T) {
+			field, _ := typ.FieldByName(tt.field)
+			schema, required := spec.fieldSchema(field)
+			if required != tt.required {
+				t.Errorf("required %v, want %v", required, tt.required)
+			}
+			if !reflect.DeepEqual(*schema, tt.want) {
+				t.Errorf("schema %+v, want %+v", *schema, tt.want)
+			}
+		})
+	}
+}
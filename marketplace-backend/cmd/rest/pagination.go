@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+
+	"github.com/spacesprotocol/marketplace/pkg/db"
+)
+
+// listingsCursor is the decoded form of the opaque cursor of the listings
+// endpoint. Price and timestamp sorts are paged by the (sort key, name) of the
+// last returned listing, relevance sort by its name alone: the relevance of a
+// name is computed again from the search.
+type listingsCursor struct {
+	SortBy    string `json:"s"`
+	SortOrder string `json:"o"`
+	Key       int64  `json:"k,omitempty"`
+	Name      string `json:"n,omitempty"`
+}
+
+func (c listingsCursor) encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeListingsCursor(cursor string) (listingsCursor, error) {
+	var c listingsCursor
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return c, fmt.Errorf("invalid cursor")
+	}
+	if err := json.Unmarshal(data, &c); err != nil {
+		return c, fmt.Errorf("invalid cursor")
+	}
+	return c, nil
+}
+
+// nextListingsCursor returns the cursor of the page following rows, "" when
+// rows is not a full page of limit listings
+func nextListingsCursor(sortBy, sortOrder string, limit int, rows []db.GetLatestListingsRow) string {
+	if len(rows) == 0 || len(rows) != limit {
+		return ""
+	}
+	last := rows[len(rows)-1]
+	next := listingsCursor{SortBy: sortBy, SortOrder: sortOrder, Name: last.Name}
+	switch sortBy {
+	case "price":
+		next.Key = last.Price
+	case "timestamp":
+		next.Key = last.Timestamp
+	}
+	return next.encode()
+}
+
+// changesCursor is the position of a consumer in the change feed. Changes
+// are ordered by the transaction which made them, then by event id, so that
+// a change committed late can never land behind a cursor already handed out.
+type changesCursor struct {
+	XactID int64 `json:"x"`
+	ID     int64 `json:"i"`
+}
+
+func (c changesCursor) encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeChangesCursor(cursor string) (changesCursor, error) {
+	var c changesCursor
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return c, fmt.Errorf("invalid cursor")
+	}
+	if err := json.Unmarshal(data, &c); err != nil {
+		return c, fmt.Errorf("invalid cursor")
+	}
+	return c, nil
+}
+
+// ListingsPage is a page of listings. It is encoded as a plain array of
+// listings, the total count and the cursor of the next page are sent in the
+// X-Total-Count and X-Next-Cursor headers.
+type ListingsPage struct {
+	Listings   []ResponseListing
+	Total      int64
+	NextCursor string
+}
+
+func (p *ListingsPage) MarshalJSON() ([]byte, error) {
+	return json.Marshal(p.Listings)
+}
+
+func (p *ListingsPage) JSONType() reflect.Type {
+	return reflect.TypeOf([]ResponseListing{})
+}
+
+func (p *ListingsPage) WriteHeaders(h http.Header) {
+	h.Set("X-Total-Count", strconv.FormatInt(p.Total, 10))
+	if p.NextCursor != "" {
+		h.Set("X-Next-Cursor", p.NextCursor)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/spacesprotocol/marketplace/pkg/db"
+)
+
+func TestListingsCursor(t *testing.T) {
+	tests := []struct {
+		name   string
+		cursor listingsCursor
+	}{
+		{name: "price", cursor: listingsCursor{SortBy: "price", SortOrder: "asc", Key: 1000, Name: "bob"}},
+		{name: "timestamp", cursor: listingsCursor{SortBy: "timestamp", SortOrder: "desc", Key: 1700000000, Name: "alice"}},
+		{name: "relevance", cursor: listingsCursor{SortBy: "relevance", SortOrder: "desc", Name: "bob"}},
+		{name: "zero key", cursor: listingsCursor{SortBy: "price", SortOrder: "asc", Name: "free"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodeListingsCursor(tt.cursor.encode())
+			if err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+			if got != tt.cursor {
+				t.Errorf("decoded %+v, want %+v", got, tt.cursor)
+			}
+		})
+	}
+}
+
+func TestDecodeInvalidCursor(t *testing.T) {
+	for _, cursor := range []string{"!!", "bm90IGpzb24", "e30=", "W10"} {
+		if _, err := decodeListingsCursor(cursor); err == nil {
+			t.Errorf("listings cursor %q decoded", cursor)
+		}
+		if _, err := decodeChangesCursor(cursor); err == nil {
+			t.Errorf("changes cursor %q decoded", cursor)
+		}
+	}
+}
+
+func TestChangesCursor(t *testing.T) {
+	for _, cursor := range []changesCursor{{}, {XactID: 812, ID: 3}, {XactID: 1 << 40, ID: 1 << 40}} {
+		got, err := decodeChangesCursor(cursor.encode())
+		if err != nil {
+			t.Fatalf("decode %+v: %v", cursor, err)
+		}
+		if got != cursor {
+			t.Errorf("decoded %+v, want %+v", got, cursor)
+		}
+	}
+}
+
+func TestNextListingsCursor(t *testing.T) {
+	rows := []db.GetLatestListingsRow{
+		{Name: "alice", Price: 500, Timestamp: 1700000100},
+		{Name: "bob", Price: 1000, Timestamp: 1700000000},
+	}
+	tests := []struct {
+		name      string
+		sortBy    string
+		sortOrder string
+		limit     int
+		rows      []db.GetLatestListingsRow
+		want      *listingsCursor
+	}{
+		{name: "price keyset", sortBy: "price", sortOrder: "asc", limit: 2, rows: rows,
+			want: &listingsCursor{SortBy: "price", SortOrder: "asc", Key: 1000, Name: "bob"}},
+		{name: "timestamp keyset", sortBy: "timestamp", sortOrder: "desc", limit: 2, rows: rows,
+			want: &listingsCursor{SortBy: "timestamp", SortOrder: "desc", Key: 1700000000, Name: "bob"}},
+		{name: "relevance keyset", sortBy: "relevance", sortOrder: "desc", limit: 2, rows: rows,
+			want: &listingsCursor{SortBy: "relevance", SortOrder: "desc", Name: "bob"}},
+		{name: "last page", sortBy: "price", sortOrder: "asc", limit: 3, rows: rows},
+		{name: "empty page", sortBy: "price", sortOrder: "asc", limit: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			next := nextListingsCursor(tt.sortBy, tt.sortOrder, tt.limit, tt.rows)
+			if tt.want == nil {
+				if next != "" {
+					t.Errorf("next cursor %q, want none", next)
+				}
+				return
+			}
+			got, err := decodeListingsCursor(next)
+			if err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+			if got != *tt.want {
+				t.Errorf("next cursor %+v, want %+v", got, *tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,359 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RateLimitConfig configures the abuse protection of /postListing. Rates are
+// in requests per minute, a zero rate disables the limiter.
+type RateLimitConfig struct {
+	IPRate      float64
+	IPBurst     float64
+	SellerRate  float64
+	SellerBurst float64
+	RejectedTTL time.Duration
+	TrustProxy  bool
+}
+
+// rateLimitConfigFromEnv reads the config from the environment, see env.example
+func rateLimitConfigFromEnv() RateLimitConfig {
+	return RateLimitConfig{
+		IPRate:      envFloat("RATE_LIMIT_IP_PER_MINUTE", 10),
+		IPBurst:     envFloat("RATE_LIMIT_IP_BURST", 5),
+		SellerRate:  envFloat("RATE_LIMIT_SELLER_PER_MINUTE", 5),
+		SellerBurst: envFloat("RATE_LIMIT_SELLER_BURST", 3),
+		RejectedTTL: time.Duration(envFloat("REJECTED_CACHE_TTL", 600)) * time.Second,
+		TrustProxy:  os.Getenv("TRUST_PROXY_HEADERS") == "true",
+	}
+}
+
+func envFloat(key string, def float64) float64 {
+	if v, err := strconv.ParseFloat(os.Getenv(key), 64); err == nil {
+		return v
+	}
+	return def
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// RateLimiter is a token bucket limiter keyed by client ip, seller, etc.
+type RateLimiter struct {
+	mu          sync.Mutex
+	rate        float64 // tokens per second
+	burst       float64
+	buckets     map[string]*tokenBucket
+	lastCleanup time.Time
+}
+
+func NewRateLimiter(perMinute, burst float64) *RateLimiter {
+	return &RateLimiter{
+		rate:        perMinute / 60,
+		burst:       math.Max(burst, 1),
+		buckets:     map[string]*tokenBucket{},
+		lastCleanup: time.Now(),
+	}
+}
+
+// Allow takes a token from the bucket of the key. If the bucket is empty it
+// returns false and the time until the next token is available.
+func (l *RateLimiter) Allow(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b := l.refill(key)
+	if b.tokens < 1 {
+		return false, l.retryAfter(b)
+	}
+	b.tokens--
+	return true, 0
+}
+
+// Peek is Allow without taking the token, see Charge
+func (l *RateLimiter) Peek(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b := l.refill(key)
+	if b.tokens < 1 {
+		return false, l.retryAfter(b)
+	}
+	return true, 0
+}
+
+// Charge takes a token from the bucket of the key, once a request allowed by
+// Peek is known to count
+func (l *RateLimiter) Charge(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.refill(key).tokens--
+}
+
+// refill returns the bucket of the key with the tokens earned since its last
+// refill
+func (l *RateLimiter) refill(key string) *tokenBucket {
+	now := time.Now()
+	l.cleanup(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	return b
+}
+
+func (l *RateLimiter) retryAfter(b *tokenBucket) time.Duration {
+	return time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+}
+
+// cleanup drops the buckets which have refilled, they are equivalent to new ones
+func (l *RateLimiter) cleanup(now time.Time) {
+	if now.Sub(l.lastCleanup) < time.Minute {
+		return
+	}
+	l.lastCleanup = now
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// withRateLimit rejects requests with 429 once the bucket of their key is
+// empty. Requests for which key returns "" are not limited.
+func withRateLimit(limiter *RateLimiter, key func(r *http.Request) string, handler http.HandlerFunc) http.HandlerFunc {
+	if limiter.rate <= 0 {
+		return handler
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if k := key(r); k != "" {
+			if ok, retryAfter := limiter.Allow(k); !ok {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+				writeError(w, TooManyRequests())
+				return
+			}
+		}
+		handler(w, r)
+	}
+}
+
+// withSuccessRateLimit is withRateLimit for keys which are not authenticated
+// before the handler, such as the seller of a posted listing: only the
+// requests which succeed take a token, so that the failing requests of others
+// cannot empty the bucket of a key.
+func withSuccessRateLimit(limiter *RateLimiter, key func(r *http.Request) string, handler http.HandlerFunc) http.HandlerFunc {
+	if limiter.rate <= 0 {
+		return handler
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		k := key(r)
+		if k == "" {
+			handler(w, r)
+			return
+		}
+		if ok, retryAfter := limiter.Peek(k); !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			writeError(w, TooManyRequests())
+			return
+		}
+
+		cw := &captureWriter{ResponseWriter: w, statusCode: http.StatusOK}
+		handler(cw, r)
+		if cw.statusCode >= 200 && cw.statusCode < 300 {
+			limiter.Charge(k)
+		}
+	}
+}
+
+// clientIP returns the ip of the client. Behind a trusted proxy it is the
+// rightmost X-Forwarded-For entry, the one added by the proxy, the entries on
+// its left are sent by the client.
+func clientIP(trustProxy bool) func(r *http.Request) string {
+	return func(r *http.Request) string {
+		if trustProxy {
+			if values := r.Header.Values("X-Forwarded-For"); len(values) > 0 {
+				forwarded := values[len(values)-1]
+				if i := strings.LastIndex(forwarded, ","); i >= 0 {
+					forwarded = forwarded[i+1:]
+				}
+				if ip := strings.TrimSpace(forwarded); ip != "" {
+					return ip
+				}
+			}
+		}
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			return r.RemoteAddr
+		}
+		return host
+	}
+}
+
+// maxListingBodyBytes bounds the body of /postListing, it is read before any
+// limiter runs
+const maxListingBodyBytes = 16 << 10
+
+// withBodyLimit rejects the request bodies larger than maxBytes
+func withBodyLimit(maxBytes int64, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Body != nil {
+			r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
+		}
+		handler(w, r)
+	}
+}
+
+// listingField returns a field of the listing posted in the request body
+func listingField(field string) func(r *http.Request) string {
+	return func(r *http.Request) string {
+		if r.Body == nil {
+			return ""
+		}
+		var body []byte
+		var err error
+		body, r.Body, err = readBody(r.Body)
+		if err != nil {
+			return ""
+		}
+		var listing map[string]interface{}
+		if err := json.Unmarshal(body, &listing); err != nil {
+			return ""
+		}
+		value, _ := listing[field].(string)
+		return strings.ToLower(value)
+	}
+}
+
+// RejectionCache remembers the listings recently rejected by the node so
+// that reposting them does not reach the node again. Signatures are public,
+// so a rejection is keyed by the whole listing, see rejectionKey.
+type RejectionCache struct {
+	mu       sync.Mutex
+	ttl      time.Duration
+	rejected map[string]rejection
+}
+
+type rejection struct {
+	message string
+	expires time.Time
+}
+
+func NewRejectionCache(ttl time.Duration) *RejectionCache {
+	return &RejectionCache{ttl: ttl, rejected: map[string]rejection{}}
+}
+
+func (c *RejectionCache) Get(key string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	r, ok := c.rejected[key]
+	if !ok {
+		return "", false
+	}
+	if time.Now().After(r.expires) {
+		delete(c.rejected, key)
+		return "", false
+	}
+	return r.message, true
+}
+
+func (c *RejectionCache) Add(key, message string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	for k, r := range c.rejected {
+		if now.After(r.expires) {
+			delete(c.rejected, k)
+		}
+	}
+	c.rejected[key] = rejection{message: message, expires: now.Add(c.ttl)}
+}
+
+// rejectionKey returns the rejection cache key of the listing posted in the
+// request body, a hash of its space, price, seller and signature. It returns
+// "" if the body is not a signed listing.
+func rejectionKey(r *http.Request) string {
+	if r.Body == nil {
+		return ""
+	}
+	var body []byte
+	var err error
+	body, r.Body, err = readBody(r.Body)
+	if err != nil {
+		return ""
+	}
+	var listing struct {
+		Space     string `json:"space"`
+		Price     int64  `json:"price"`
+		Seller    string `json:"seller"`
+		Signature string `json:"signature"`
+	}
+	if err := json.Unmarshal(body, &listing); err != nil || listing.Signature == "" {
+		return ""
+	}
+	hash := sha256.Sum256([]byte(fmt.Sprintf("%s\x00%d\x00%s\x00%s",
+		listing.Space, listing.Price, listing.Seller, strings.ToLower(listing.Signature))))
+	return hex.EncodeToString(hash[:])
+}
+
+// captureWriter keeps the status and body of a response
+type captureWriter struct {
+	http.ResponseWriter
+	statusCode int
+	body       bytes.Buffer
+}
+
+func (cw *captureWriter) WriteHeader(statusCode int) {
+	cw.statusCode = statusCode
+	cw.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (cw *captureWriter) Write(b []byte) (int, error) {
+	cw.body.Write(b)
+	return cw.ResponseWriter.Write(b)
+}
+
+// withRejectionCache answers reposts of a recently rejected listing from the
+// cache and records new verification rejections
+func withRejectionCache(cache *RejectionCache, handler http.HandlerFunc) http.HandlerFunc {
+	if cache.ttl <= 0 {
+		return handler
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := rejectionKey(r)
+		if key != "" {
+			if message, ok := cache.Get(key); ok {
+				writeError(w, VerificationRejected(message))
+				return
+			}
+		}
+
+		cw := &captureWriter{ResponseWriter: w, statusCode: http.StatusOK}
+		handler(cw, r)
+
+		if key == "" || cw.statusCode != http.StatusUnprocessableEntity {
+			return
+		}
+		var resp ErrorResponse
+		if err := json.Unmarshal(cw.body.Bytes(), &resp); err == nil && resp.Code == CodeVerificationRejected {
+			cache.Add(key, resp.Error)
+		}
+	}
+}
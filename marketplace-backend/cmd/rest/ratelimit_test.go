@@ -0,0 +1,149 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterAllow(t *testing.T) {
+	tests := []struct {
+		name      string
+		perMinute float64
+		burst     float64
+		calls     int
+		// elapsed is added to the last refill of the bucket before the last call
+		elapsed time.Duration
+		allowed int
+	}{
+		{name: "within burst", perMinute: 60, burst: 3, calls: 3, allowed: 3},
+		{name: "over burst", perMinute: 60, burst: 3, calls: 5, allowed: 3},
+		{name: "burst below one allows one", perMinute: 60, burst: 0, calls: 2, allowed: 1},
+		{name: "refilled after a token interval", perMinute: 60, burst: 1, calls: 2, elapsed: time.Second, allowed: 2},
+		{name: "not refilled before a token interval", perMinute: 60, burst: 1, calls: 2, elapsed: 500 * time.Millisecond, allowed: 1},
+		{name: "refill capped at burst", perMinute: 60, burst: 2, calls: 4, elapsed: time.Hour, allowed: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limiter := NewRateLimiter(tt.perMinute, tt.burst)
+			allowed := 0
+			for i := 0; i < tt.calls; i++ {
+				if i == tt.calls-1 && tt.elapsed > 0 {
+					limiter.buckets["key"].last = limiter.buckets["key"].last.Add(-tt.elapsed)
+				}
+				if ok, retryAfter := limiter.Allow("key"); ok {
+					allowed++
+				} else if retryAfter <= 0 {
+					t.Errorf("call %d: denied without a retry delay", i)
+				}
+			}
+			if allowed != tt.allowed {
+				t.Errorf("allowed %d calls, want %d", allowed, tt.allowed)
+			}
+		})
+	}
+}
+
+func TestRateLimiterAllowKeys(t *testing.T) {
+	limiter := NewRateLimiter(60, 1)
+	if ok, _ := limiter.Allow("a"); !ok {
+		t.Fatal("first call of a denied")
+	}
+	if ok, _ := limiter.Allow("b"); !ok {
+		t.Fatal("first call of b denied, buckets are shared")
+	}
+	ok, retryAfter := limiter.Allow("a")
+	if ok {
+		t.Fatal("second call of a allowed")
+	}
+	if retryAfter <= 0 || retryAfter > time.Second {
+		t.Errorf("retry after %s, want at most a second", retryAfter)
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		name       string
+		trustProxy bool
+		forwarded  []string
+		want       string
+	}{
+		{name: "remote address", want: "10.0.0.1"},
+		{name: "untrusted header", forwarded: []string{"1.1.1.1"}, want: "10.0.0.1"},
+		{name: "single entry", trustProxy: true, forwarded: []string{"1.1.1.1"}, want: "1.1.1.1"},
+		{name: "spoofed entries", trustProxy: true, forwarded: []string{"6.6.6.6, 1.1.1.1"}, want: "1.1.1.1"},
+		{name: "several headers", trustProxy: true, forwarded: []string{"6.6.6.6", "7.7.7.7,1.1.1.1"}, want: "1.1.1.1"},
+		{name: "empty entry", trustProxy: true, forwarded: []string{"1.1.1.1, "}, want: "10.0.0.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/postListing", nil)
+			r.RemoteAddr = "10.0.0.1:4242"
+			for _, value := range tt.forwarded {
+				r.Header.Add("X-Forwarded-For", value)
+			}
+			if got := clientIP(tt.trustProxy)(r); got != tt.want {
+				t.Errorf("clientIP = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRejectionKey(t *testing.T) {
+	key := func(body string) string {
+		return rejectionKey(httptest.NewRequest(http.MethodPost, "/postListing", strings.NewReader(body)))
+	}
+	listing := `{"space":"@bob","price":1000,"seller":"bc1q","signature":"AB01"}`
+	base := key(listing)
+	if base == "" {
+		t.Fatal("no key for a signed listing")
+	}
+	if got := key(`{"space":"@bob","price":1000,"seller":"bc1q","signature":"ab01"}`); got != base {
+		t.Error("the key depends on the case of the signature")
+	}
+	for _, other := range []string{
+		`{"space":"@alice","price":1000,"seller":"bc1q","signature":"AB01"}`,
+		`{"space":"@bob","price":1,"seller":"bc1q","signature":"AB01"}`,
+		`{"space":"@bob","price":1000,"seller":"bc1p","signature":"AB01"}`,
+	} {
+		if key(other) == base {
+			t.Errorf("%s has the key of %s", other, listing)
+		}
+	}
+	for _, invalid := range []string{`{"space":"@bob","price":1000}`, `not json`} {
+		if got := key(invalid); got != "" {
+			t.Errorf("key of %s = %q, want none", invalid, got)
+		}
+	}
+}
+
+func TestWithSuccessRateLimit(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []int
+		// allowed is the number of requests which reach the handler
+		allowed int
+	}{
+		{name: "accepted listings charged", statuses: []int{http.StatusOK, http.StatusOK, http.StatusOK}, allowed: 2},
+		{name: "rejected listings not charged", statuses: []int{http.StatusUnprocessableEntity, http.StatusUnprocessableEntity, http.StatusOK, http.StatusOK}, allowed: 4},
+		{name: "node failures not charged", statuses: []int{http.StatusServiceUnavailable, http.StatusOK, http.StatusOK, http.StatusOK}, allowed: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			handler := withSuccessRateLimit(NewRateLimiter(1, 2), listingField("seller"), func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(tt.statuses[calls])
+				calls++
+			})
+			for range tt.statuses {
+				r := httptest.NewRequest(http.MethodPost, "/postListing", strings.NewReader(`{"seller":"bc1q"}`))
+				handler(httptest.NewRecorder(), r)
+			}
+			if calls != tt.allowed {
+				t.Errorf("%d requests reached the handler, want %d", calls, tt.allowed)
+			}
+		})
+	}
+}
@@ -10,7 +10,7 @@ import (
 	"time"
 
 	"github.com/jackc/pgx/v5/pgxpool"
-	"github.com/spacesprotocol/explorer-indexer/pkg/node"
+	"github.com/spacesprotocol/marketplace/pkg/store"
 )
 
 func main() {
@@ -29,24 +29,64 @@ func main() {
 	}
 	defer pg.Close()
 
-	client := node.NewClient(os.Getenv("SPACES_NODE_URI"), os.Getenv("RPC_USER"), os.Getenv("RPC_PASSWORD"))
-	spacesClient := node.SpacesClient{Client: client}
+	spacesClient := store.NewSpacesClient(os.Getenv("SPACES_NODE_URI"), os.Getenv("RPC_USER"), os.Getenv("RPC_PASSWORD"))
+	if codes := os.Getenv("VERIFY_REJECTION_CODES"); codes != "" {
+		if spacesClient.RejectionCodes, err = store.ParseRejectionCodes(codes); err != nil {
+			log.Fatalln(err)
+		}
+	}
+	expiringSoonBlocks = int32(envFloat("EXPIRING_SOON_BLOCKS", float64(expiringSoonBlocks)))
+
+	rateLimit := rateLimitConfigFromEnv()
+	ipLimiter := NewRateLimiter(rateLimit.IPRate, rateLimit.IPBurst)
+	sellerLimiter := NewRateLimiter(rateLimit.SellerRate, rateLimit.SellerBurst)
+	rejectionCache := NewRejectionCache(rateLimit.RejectedTTL)
+
+	// the routes are served and documented from this single list
+	routes := Routes{
+		NewAction(http.MethodGet, healthCheckHandler).WithPath("/healthcheck"),
+		NewAction(http.MethodGet, getListingHandler).WithPath("/space/{name}").WithCaching(),
+		NewAction(http.MethodGet, getSpaceListingsHandler).WithPath("/space/{name}/listings").WithCaching(),
+		NewAction(http.MethodGet, getSpaceHistoryHandler).WithPath("/space/{name}/history").WithCaching(),
+		NewAction(http.MethodGet, getSpaceSalesHandler).WithPath("/space/{name}/sales").WithCaching(),
+		NewAction(http.MethodGet, getListingsHandler).WithPath("/listings").WithCaching(),
+		// the seller is only known once the listing is verified, its bucket
+		// is charged for the accepted listings alone
+		NewAction(http.MethodPost, postListingHandler).WithPath("/postListing").
+			WithBodyLimit(maxListingBodyBytes).
+			WithMiddleware(
+				func(h http.HandlerFunc) http.HandlerFunc {
+					return withRateLimit(ipLimiter, clientIP(rateLimit.TrustProxy), h)
+				},
+				func(h http.HandlerFunc) http.HandlerFunc { return withRejectionCache(rejectionCache, h) },
+				func(h http.HandlerFunc) http.HandlerFunc {
+					return withSuccessRateLimit(sellerLimiter, listingField("seller"), h)
+				},
+			),
+		NewAction(http.MethodGet, getListingEventsHandler).WithPath("/events").WithCaching(),
+		NewAction(http.MethodPost, getSpacesHandler).WithPath("/spaces"),
+		NewAction(http.MethodGet, getSellerListingsHandler).WithPath("/seller/{address}").WithCaching(),
+		NewAction(http.MethodGet, getChangesHandler).WithPath("/changes"),
+		NewAction(http.MethodGet, getStatsHandler).WithPath("/stats"),
+		NewAction(http.MethodGet, getSalesHandler).WithPath("/sales").WithCaching(),
+	}
+	openAPI := NewOpenAPI(routes)
 
-	getListing := NewAction(http.MethodGet, getListingHandler)
-	getListings := NewAction(http.MethodGet, getListingsHandler)
-	postListing := NewAction(http.MethodPost, postListingHandler)
-	healthCheck := NewAction(http.MethodGet, healthCheckHandler)
+	broker := NewBroker(pg)
+	brokerCtx, stopBroker := context.WithCancel(context.Background())
+	defer stopBroker()
+	go broker.Run(brokerCtx)
 
 	mux := http.NewServeMux()
-	mux.HandleFunc("/healthcheck", healthCheck.BuildLoggedHandler(pg, spacesClient))
-	mux.HandleFunc("/space/", getListing.BuildLoggedHandler(pg, spacesClient))
-	mux.HandleFunc("/listings", getListings.BuildLoggedHandler(pg, spacesClient))
-	mux.HandleFunc("/postListing", postListing.BuildLoggedHandler(pg, spacesClient))
+	mux.HandleFunc("/stream", withLogging(broker.Handler()))
+	mux.HandleFunc("/openapi.json", withLogging(openAPI.Handler()))
+	routes.Register(mux, pg, spacesClient)
 
 	srv := &http.Server{
 		Addr:    ":" + port,
 		Handler: mux,
 	}
+	srv.RegisterOnShutdown(broker.Close)
 
 	go func() {
 		log.Printf("Starting server at %s", srv.Addr)
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// listingEventsChannel is the postgres channel on which every inserted
+// listing event is notified, see the listing_events_notify trigger. Both the
+// indexer and /postListing insert listing events, so the channel carries the
+// changes of both processes.
+const listingEventsChannel = "listing_events"
+
+// StreamEvent is a marketplace change pushed to the stream subscribers
+type StreamEvent struct {
+	ID         int64  `json:"id"`
+	Event      string `json:"event"`
+	Space      string `json:"space"`
+	Signature  string `json:"signature"`
+	Price      int64  `json:"price"`
+	Seller     string `json:"seller"`
+	Valid      bool   `json:"valid"`
+	Height     int32  `json:"height"`
+	Txid       string `json:"txid,omitempty"`
+	SpaceEvent string `json:"space_event,omitempty"`
+	Reason     string `json:"reason,omitempty"`
+	Timestamp  int64  `json:"timestamp"`
+
+	data []byte
+}
+
+// Broker listens to the listing events notifications and fans them out to
+// the stream subscribers
+type Broker struct {
+	pg          *pgxpool.Pool
+	mu          sync.Mutex
+	subscribers map[chan *StreamEvent]struct{}
+	closed      bool
+}
+
+func NewBroker(pg *pgxpool.Pool) *Broker {
+	return &Broker{pg: pg, subscribers: map[chan *StreamEvent]struct{}{}}
+}
+
+// Run listens until the context is cancelled, reconnecting on errors
+func (b *Broker) Run(ctx context.Context) {
+	for ctx.Err() == nil {
+		if err := b.listen(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("listing events listener failed: %v", err)
+			time.Sleep(time.Second)
+		}
+	}
+}
+
+func (b *Broker) listen(ctx context.Context) error {
+	poolConn, err := b.pg.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	// the listening connection is taken out of the pool for good
+	conn := poolConn.Hijack()
+	defer conn.Close(context.Background())
+
+	if _, err := conn.Exec(ctx, "LISTEN "+listingEventsChannel); err != nil {
+		return err
+	}
+
+	for {
+		notification, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+		event := &StreamEvent{}
+		if err := json.Unmarshal([]byte(notification.Payload), event); err != nil {
+			log.Printf("invalid listing event notification: %v", err)
+			continue
+		}
+		event.data = []byte(notification.Payload)
+		b.publish(event)
+	}
+}
+
+// publish sends the event to every subscriber, subscribers which are too slow
+// to keep up miss events rather than block the others
+func (b *Broker) publish(event *StreamEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subscribers {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+func (b *Broker) Subscribe() (chan *StreamEvent, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return nil, false
+	}
+	ch := make(chan *StreamEvent, 64)
+	b.subscribers[ch] = struct{}{}
+	return ch, true
+}
+
+func (b *Broker) Unsubscribe(ch chan *StreamEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.subscribers[ch]; ok {
+		delete(b.subscribers, ch)
+		close(ch)
+	}
+}
+
+// Close ends all the streams, it is called on server shutdown
+func (b *Broker) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	for ch := range b.subscribers {
+		delete(b.subscribers, ch)
+		close(ch)
+	}
+}
+
+// Handler serves the server-sent events stream. The optional space query
+// parameter restricts the stream to the events of one space.
+func (b *Broker) Handler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeError(w, MethodNotAllowed(r.Method))
+			return
+		}
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			writeError(w, Internal("streaming is not supported", nil))
+			return
+		}
+
+		space := strings.TrimPrefix(r.URL.Query().Get("space"), "@")
+
+		ch, ok := b.Subscribe()
+		if !ok {
+			writeError(w, Unavailable("server is shutting down"))
+			return
+		}
+		defer b.Unsubscribe(ch)
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		w.Header().Set("X-Accel-Buffering", "no")
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprint(w, ": connected\n\n")
+		flusher.Flush()
+
+		heartbeat := time.NewTicker(25 * time.Second)
+		defer heartbeat.Stop()
+
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-heartbeat.C:
+				fmt.Fprint(w, ": heartbeat\n\n")
+				flusher.Flush()
+			case event, ok := <-ch:
+				if !ok {
+					return
+				}
+				if space != "" && event.Space != space {
+					continue
+				}
+				fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Event, event.data)
+				flusher.Flush()
+			}
+		}
+	}
+}
@@ -2,26 +2,37 @@ package main
 
 import (
 	"context"
+	"reflect"
+	"strings"
 
 	"github.com/go-playground/validator/v10"
-	"github.com/spacesprotocol/explorer-indexer/pkg/node"
 	"github.com/spacesprotocol/marketplace/pkg/db"
+	"github.com/spacesprotocol/marketplace/pkg/store"
 )
 
 type Context struct {
 	context.Context
 	DB        *db.Queries
-	Spaces    node.SpacesClient
+	Spaces    *store.SpacesClient
 	Validator *validator.Validate
 }
 
 // NewContext creates a new context with initialized validator
-func NewContext(ctx context.Context, queries *db.Queries, spaces node.SpacesClient) *Context {
+func NewContext(ctx context.Context, queries *db.Queries, spaces *store.SpacesClient) *Context {
+	v := validator.New()
+	// report fields by their JSON name
+	v.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "" || name == "-" {
+			return field.Name
+		}
+		return name
+	})
 	return &Context{
 		Context:   ctx,
 		DB:        queries,
 		Spaces:    spaces,
-		Validator: validator.New(),
+		Validator: v,
 	}
 }
 
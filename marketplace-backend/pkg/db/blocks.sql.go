@@ -9,6 +9,29 @@ import (
 	"context"
 )
 
+const deleteBlocksAfterHeight = `-- name: DeleteBlocksAfterHeight :exec
+DELETE FROM blocks
+WHERE height > $1
+`
+
+func (q *Queries) DeleteBlocksAfterHeight(ctx context.Context, height int32) error {
+	_, err := q.db.Exec(ctx, deleteBlocksAfterHeight, height)
+	return err
+}
+
+const getBlockHashByHeight = `-- name: GetBlockHashByHeight :one
+SELECT hash
+FROM blocks
+WHERE height = $1
+`
+
+func (q *Queries) GetBlockHashByHeight(ctx context.Context, height int32) ([]byte, error) {
+	row := q.db.QueryRow(ctx, getBlockHashByHeight, height)
+	var hash []byte
+	err := row.Scan(&hash)
+	return hash, err
+}
+
 const getBlocksMaxHeight = `-- name: GetBlocksMaxHeight :one
 SELECT COALESCE(MAX(height), -1)::integer
 FROM blocks
@@ -9,6 +9,64 @@ import (
 	"context"
 )
 
+const countLatestListings = `-- name: CountLatestListings :one
+SELECT COUNT(DISTINCT name)
+FROM listings
+WHERE valid = true
+  AND (
+    $1::text = ''
+    OR ($2::text IN ('any', 'prefix') AND name LIKE $3::text || '%')
+    OR ($2::text IN ('any', 'substring') AND name LIKE '%' || $3::text || '%')
+    OR ($2::text IN ('any', 'fuzzy') AND name % $1::text)
+  )
+  AND ($4::bigint < 0 OR price >= $4::bigint)
+  AND ($5::bigint < 0 OR price <= $5::bigint)
+  AND ($6::integer < 0 OR char_length(name) >= $6::integer)
+  AND ($7::integer < 0 OR char_length(name) <= $7::integer)
+  AND (
+    $8::text = ''
+    OR ($8::text = 'digits' AND name ~ '^[0-9]+$')
+    OR ($8::text = 'letters' AND name ~ '^[a-z]+$')
+    OR ($8::text = 'idn' AND name LIKE 'xn--%')
+  )
+  AND ($9::text = '' OR seller = $9::text)
+  AND ($10::integer < 0 OR (SELECT min(e.height) FROM listing_events e WHERE e.signature = listings.signature AND e.event = 'created') >= $10::integer)
+  AND ($11::integer < 0 OR (SELECT min(e.height) FROM listing_events e WHERE e.signature = listings.signature AND e.event = 'created') <= $11::integer)
+`
+
+type CountLatestListingsParams struct {
+	Search     string
+	SearchMode string
+	SearchLike string
+	MinPrice   int64
+	MaxPrice   int64
+	MinLength  int32
+	MaxLength  int32
+	Charset    string
+	Seller     string
+	MinHeight  int32
+	MaxHeight  int32
+}
+
+func (q *Queries) CountLatestListings(ctx context.Context, arg CountLatestListingsParams) (int64, error) {
+	row := q.db.QueryRow(ctx, countLatestListings,
+		arg.Search,
+		arg.SearchMode,
+		arg.SearchLike,
+		arg.MinPrice,
+		arg.MaxPrice,
+		arg.MinLength,
+		arg.MaxLength,
+		arg.Charset,
+		arg.Seller,
+		arg.MinHeight,
+		arg.MaxHeight,
+	)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
 const getLatestListings = `-- name: GetLatestListings :many
 WITH RankedListings AS (
   SELECT DISTINCT ON (name)
@@ -20,24 +78,70 @@ WITH RankedListings AS (
     height
   FROM listings
   WHERE valid = true
+    AND (
+      $1::text = ''
+      OR ($2::text IN ('any', 'prefix') AND name LIKE $3::text || '%')
+      OR ($2::text IN ('any', 'substring') AND name LIKE '%' || $3::text || '%')
+      OR ($2::text IN ('any', 'fuzzy') AND name % $1::text)
+    )
+    AND ($4::bigint < 0 OR price >= $4::bigint)
+    AND ($5::bigint < 0 OR price <= $5::bigint)
+    AND ($6::integer < 0 OR char_length(name) >= $6::integer)
+    AND ($7::integer < 0 OR char_length(name) <= $7::integer)
+    AND (
+      $8::text = ''
+      OR ($8::text = 'digits' AND name ~ '^[0-9]+$')
+      OR ($8::text = 'letters' AND name ~ '^[a-z]+$')
+      OR ($8::text = 'idn' AND name LIKE 'xn--%')
+    )
+    AND ($9::text = '' OR seller = $9::text)
+    AND ($10::integer < 0 OR (SELECT min(e.height) FROM listing_events e WHERE e.signature = listings.signature AND e.event = 'created') >= $10::integer)
+    AND ($11::integer < 0 OR (SELECT min(e.height) FROM listing_events e WHERE e.signature = listings.signature AND e.event = 'created') <= $11::integer)
   ORDER BY name, price ASC
 )
-SELECT name, price, seller, signature, timestamp, height
+SELECT name, price, seller, signature, timestamp, height, similarity(name, $1::text)::real AS relevance
 FROM RankedListings
+WHERE $12::text = ''
+  OR ($13::text = 'price' AND $14::text = 'asc' AND (price, name) > ($15::bigint, $12::text))
+  OR ($13::text = 'price' AND $14::text = 'desc' AND (price < $15::bigint OR (price = $15::bigint AND name > $12::text)))
+  OR ($13::text = 'timestamp' AND $14::text = 'asc' AND (timestamp, name) > ($15::bigint, $12::text))
+  OR ($13::text = 'timestamp' AND $14::text = 'desc' AND (timestamp < $15::bigint OR (timestamp = $15::bigint AND name > $12::text)))
+  -- the relevance of the last name is computed again, the rank is negated to
+  -- compare the descending relevance and the ascending name as one tuple
+  OR ($13::text = 'relevance'
+    AND (name <> $1::text, name NOT LIKE $3::text || '%', -similarity(name, $1::text), name)
+      > ($12::text <> $1::text, $12::text NOT LIKE $3::text || '%', -similarity($12::text, $1::text), $12::text))
 ORDER BY
-  CASE WHEN $1::text = 'price' AND $2::text = 'asc' THEN price END ASC,
-  CASE WHEN $1::text = 'price' AND $2::text = 'desc' THEN price END DESC,
-  CASE WHEN $1::text = 'timestamp' AND $2::text = 'asc' THEN timestamp END ASC,
-  CASE WHEN $1::text = 'timestamp' AND $2::text = 'desc' THEN timestamp END DESC
-limit $4
-OFFSET $3
+  CASE WHEN $13::text = 'relevance' THEN name = $1::text END DESC,
+  CASE WHEN $13::text = 'relevance' THEN name LIKE $3::text || '%' END DESC,
+  CASE WHEN $13::text = 'relevance' THEN similarity(name, $1::text) END DESC,
+  CASE WHEN $13::text = 'price' AND $14::text = 'asc' THEN price END ASC,
+  CASE WHEN $13::text = 'price' AND $14::text = 'desc' THEN price END DESC,
+  CASE WHEN $13::text = 'timestamp' AND $14::text = 'asc' THEN timestamp END ASC,
+  CASE WHEN $13::text = 'timestamp' AND $14::text = 'desc' THEN timestamp END DESC,
+  name
+limit $17
+OFFSET $16
 `
 
 type GetLatestListingsParams struct {
-	SortBy    string
-	SortOrder string
-	Offset    int32
-	Limit     int32
+	Search     string
+	SearchMode string
+	SearchLike string
+	MinPrice   int64
+	MaxPrice   int64
+	MinLength  int32
+	MaxLength  int32
+	Charset    string
+	Seller     string
+	MinHeight  int32
+	MaxHeight  int32
+	AfterName  string
+	SortBy     string
+	SortOrder  string
+	AfterKey   int64
+	Offset     int32
+	Limit      int32
 }
 
 type GetLatestListingsRow struct {
@@ -47,12 +151,28 @@ type GetLatestListingsRow struct {
 	Signature []byte
 	Timestamp int64
 	Height    int32
+	Relevance float32
 }
 
+// the height filters apply to the block height at which the listing was
+// posted, the height of its created event
 func (q *Queries) GetLatestListings(ctx context.Context, arg GetLatestListingsParams) ([]GetLatestListingsRow, error) {
 	rows, err := q.db.Query(ctx, getLatestListings,
+		arg.Search,
+		arg.SearchMode,
+		arg.SearchLike,
+		arg.MinPrice,
+		arg.MaxPrice,
+		arg.MinLength,
+		arg.MaxLength,
+		arg.Charset,
+		arg.Seller,
+		arg.MinHeight,
+		arg.MaxHeight,
+		arg.AfterName,
 		arg.SortBy,
 		arg.SortOrder,
+		arg.AfterKey,
 		arg.Offset,
 		arg.Limit,
 	)
@@ -70,6 +190,7 @@ func (q *Queries) GetLatestListings(ctx context.Context, arg GetLatestListingsPa
 			&i.Signature,
 			&i.Timestamp,
 			&i.Height,
+			&i.Relevance,
 		); err != nil {
 			return nil, err
 		}
@@ -82,7 +203,7 @@ func (q *Queries) GetLatestListings(ctx context.Context, arg GetLatestListingsPa
 }
 
 const getListingByName = `-- name: GetListingByName :many
-SELECT name, price, seller, signature, timestamp, height, valid 
+SELECT name, price, seller, signature, timestamp, height, valid, last_verified_at 
 FROM listings
 WHERE name = $1 order by price asc
 `
@@ -104,6 +225,185 @@ func (q *Queries) GetListingByName(ctx context.Context, name string) ([]Listing,
 			&i.Timestamp,
 			&i.Height,
 			&i.Valid,
+			&i.LastVerifiedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getListingBySignature = `-- name: GetListingBySignature :many
+SELECT name, price, seller, signature, timestamp, height, valid, last_verified_at
+FROM listings
+WHERE signature = $1
+`
+
+func (q *Queries) GetListingBySignature(ctx context.Context, signature []byte) ([]Listing, error) {
+	rows, err := q.db.Query(ctx, getListingBySignature, signature)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []Listing{}
+	for rows.Next() {
+		var i Listing
+		if err := rows.Scan(
+			&i.Name,
+			&i.Price,
+			&i.Seller,
+			&i.Signature,
+			&i.Timestamp,
+			&i.Height,
+			&i.Valid,
+			&i.LastVerifiedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getListingsAfterHeight = `-- name: GetListingsAfterHeight :many
+SELECT name, price, seller, signature, timestamp, height, valid, last_verified_at
+FROM listings
+WHERE (height > $1
+   OR signature IN (SELECT signature FROM listing_events WHERE listing_events.height > $1))
+  AND coalesce((SELECT e.event FROM listing_events e WHERE e.signature = listings.signature ORDER BY e.id DESC LIMIT 1), '') <> 'superseded'
+`
+
+// the listings changed by a block above height: revalidated listings are
+// stored with height 0, their events keep the height of the change. The
+// superseded listings are left out, they stay invalid whatever the node says.
+func (q *Queries) GetListingsAfterHeight(ctx context.Context, height int32) ([]Listing, error) {
+	rows, err := q.db.Query(ctx, getListingsAfterHeight, height)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []Listing{}
+	for rows.Next() {
+		var i Listing
+		if err := rows.Scan(
+			&i.Name,
+			&i.Price,
+			&i.Seller,
+			&i.Signature,
+			&i.Timestamp,
+			&i.Height,
+			&i.Valid,
+			&i.LastVerifiedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getListingsBySeller = `-- name: GetListingsBySeller :many
+SELECT name, price, seller, signature, timestamp, height, valid, last_verified_at
+FROM listings
+WHERE seller = $1
+  AND ($2::text = 'all' OR valid = ($2::text = 'valid'))
+ORDER BY
+  valid DESC,
+  CASE WHEN $3::text = 'price' AND $4::text = 'asc' THEN price END ASC,
+  CASE WHEN $3::text = 'price' AND $4::text = 'desc' THEN price END DESC,
+  CASE WHEN $3::text = 'timestamp' AND $4::text = 'asc' THEN timestamp END ASC,
+  CASE WHEN $3::text = 'timestamp' AND $4::text = 'desc' THEN timestamp END DESC,
+  name
+limit $6
+OFFSET $5
+`
+
+type GetListingsBySellerParams struct {
+	Seller    string
+	Status    string
+	SortBy    string
+	SortOrder string
+	Offset    int32
+	Limit     int32
+}
+
+func (q *Queries) GetListingsBySeller(ctx context.Context, arg GetListingsBySellerParams) ([]Listing, error) {
+	rows, err := q.db.Query(ctx, getListingsBySeller,
+		arg.Seller,
+		arg.Status,
+		arg.SortBy,
+		arg.SortOrder,
+		arg.Offset,
+		arg.Limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []Listing{}
+	for rows.Next() {
+		var i Listing
+		if err := rows.Scan(
+			&i.Name,
+			&i.Price,
+			&i.Seller,
+			&i.Signature,
+			&i.Timestamp,
+			&i.Height,
+			&i.Valid,
+			&i.LastVerifiedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getListingsToRevalidate = `-- name: GetListingsToRevalidate :many
+SELECT name, price, seller, signature, timestamp, height, valid, last_verified_at
+FROM listings
+WHERE valid = true AND last_verified_at < $1::bigint
+ORDER BY last_verified_at, signature
+limit $2
+`
+
+type GetListingsToRevalidateParams struct {
+	VerifiedBefore int64
+	Limit          int32
+}
+
+func (q *Queries) GetListingsToRevalidate(ctx context.Context, arg GetListingsToRevalidateParams) ([]Listing, error) {
+	rows, err := q.db.Query(ctx, getListingsToRevalidate, arg.VerifiedBefore, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []Listing{}
+	for rows.Next() {
+		var i Listing
+		if err := rows.Scan(
+			&i.Name,
+			&i.Price,
+			&i.Seller,
+			&i.Signature,
+			&i.Timestamp,
+			&i.Height,
+			&i.Valid,
+			&i.LastVerifiedAt,
 		); err != nil {
 			return nil, err
 		}
@@ -116,7 +416,7 @@ func (q *Queries) GetListingByName(ctx context.Context, name string) ([]Listing,
 }
 
 const getValidListingByName = `-- name: GetValidListingByName :many
-SELECT name, price, seller, signature, timestamp, height, valid 
+SELECT name, price, seller, signature, timestamp, height, valid, last_verified_at 
 FROM listings
 WHERE name = $1 and valid = true order by price asc limit 1
 `
@@ -138,6 +438,82 @@ func (q *Queries) GetValidListingByName(ctx context.Context, name string) ([]Lis
 			&i.Timestamp,
 			&i.Height,
 			&i.Valid,
+			&i.LastVerifiedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getValidListingsByNames = `-- name: GetValidListingsByNames :many
+SELECT DISTINCT ON (name) name, price, seller, signature, timestamp, height, valid, last_verified_at
+FROM listings
+WHERE name = ANY($1::text[]) AND valid = true
+ORDER BY name, price ASC
+`
+
+func (q *Queries) GetValidListingsByNames(ctx context.Context, names []string) ([]Listing, error) {
+	rows, err := q.db.Query(ctx, getValidListingsByNames, names)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []Listing{}
+	for rows.Next() {
+		var i Listing
+		if err := rows.Scan(
+			&i.Name,
+			&i.Price,
+			&i.Seller,
+			&i.Signature,
+			&i.Timestamp,
+			&i.Height,
+			&i.Valid,
+			&i.LastVerifiedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getVerifiableListingsByName = `-- name: GetVerifiableListingsByName :many
+SELECT name, price, seller, signature, timestamp, height, valid, last_verified_at
+FROM listings
+WHERE name = $1
+  AND coalesce((SELECT e.event FROM listing_events e WHERE e.signature = listings.signature ORDER BY e.id DESC LIMIT 1), '') <> 'superseded'
+ORDER BY price ASC
+`
+
+// the listings of a space whose validity follows the node, all but the
+// superseded ones
+func (q *Queries) GetVerifiableListingsByName(ctx context.Context, name string) ([]Listing, error) {
+	rows, err := q.db.Query(ctx, getVerifiableListingsByName, name)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []Listing{}
+	for rows.Next() {
+		var i Listing
+		if err := rows.Scan(
+			&i.Name,
+			&i.Price,
+			&i.Seller,
+			&i.Signature,
+			&i.Timestamp,
+			&i.Height,
+			&i.Valid,
+			&i.LastVerifiedAt,
 		); err != nil {
 			return nil, err
 		}
@@ -179,10 +555,27 @@ func (q *Queries) InsertListing(ctx context.Context, arg InsertListingParams) er
 	return err
 }
 
-const updateListingValidityAndHeight = `-- name: UpdateListingValidityAndHeight :exec
+const setListingsVerifiedAt = `-- name: SetListingsVerifiedAt :exec
+UPDATE listings
+SET last_verified_at = $1::bigint
+WHERE signature = ANY($2::bytea[])
+`
+
+type SetListingsVerifiedAtParams struct {
+	VerifiedAt int64
+	Signatures [][]byte
+}
+
+func (q *Queries) SetListingsVerifiedAt(ctx context.Context, arg SetListingsVerifiedAtParams) error {
+	_, err := q.db.Exec(ctx, setListingsVerifiedAt, arg.VerifiedAt, arg.Signatures)
+	return err
+}
+
+const updateListingValidityAndHeight = `-- name: UpdateListingValidityAndHeight :execrows
 UPDATE listings
 SET valid = $2, height = $3
 WHERE signature = $1
+  AND valid <> $2
 `
 
 type UpdateListingValidityAndHeightParams struct {
@@ -191,9 +584,14 @@ type UpdateListingValidityAndHeightParams struct {
 	Height    int32
 }
 
-func (q *Queries) UpdateListingValidityAndHeight(ctx context.Context, arg UpdateListingValidityAndHeightParams) error {
-	_, err := q.db.Exec(ctx, updateListingValidityAndHeight, arg.Signature, arg.Valid, arg.Height)
-	return err
+// only a change of validity is written, the caller records an event for it.
+// The height of a listing which stays invalid is the one of its invalidation.
+func (q *Queries) UpdateListingValidityAndHeight(ctx context.Context, arg UpdateListingValidityAndHeightParams) (int64, error) {
+	result, err := q.db.Exec(ctx, updateListingValidityAndHeight, arg.Signature, arg.Valid, arg.Height)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
 }
 
 const upsertListing = `-- name: UpsertListing :exec
@@ -203,25 +601,28 @@ INSERT INTO listings (
     seller,
     signature,
     height,
-    valid
+    valid,
+    last_verified_at
 )
-VALUES ($1, $2, $3, $4, $5, $6)
+VALUES ($1, $2, $3, $4, $5, $6, $7)
 ON CONFLICT (signature)
 DO UPDATE SET
         name = EXCLUDED.name,
         price = EXCLUDED.price,
         seller = EXCLUDED.seller,
         height = EXCLUDED.height,
-        valid = EXCLUDED.valid
+        valid = EXCLUDED.valid,
+        last_verified_at = EXCLUDED.last_verified_at
 `
 
 type UpsertListingParams struct {
-	Name      string
-	Price     int64
-	Seller    string
-	Signature []byte
-	Height    int32
-	Valid     bool
+	Name           string
+	Price          int64
+	Seller         string
+	Signature      []byte
+	Height         int32
+	Valid          bool
+	LastVerifiedAt int64
 }
 
 func (q *Queries) UpsertListing(ctx context.Context, arg UpsertListingParams) error {
@@ -232,6 +633,7 @@ func (q *Queries) UpsertListing(ctx context.Context, arg UpsertListingParams) er
 		arg.Signature,
 		arg.Height,
 		arg.Valid,
+		arg.LastVerifiedAt,
 	)
 	return err
 }
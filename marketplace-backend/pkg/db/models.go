@@ -10,11 +10,74 @@ type Block struct {
 }
 
 type Listing struct {
+	Name           string
+	Price          int64
+	Seller         string
+	Signature      []byte
+	Timestamp      int64
+	Height         int32
+	Valid          bool
+	LastVerifiedAt int64
+}
+
+type ListingEvent struct {
+	ID               int64
+	Signature        []byte
+	Name             string
+	Event            string
+	Height           int32
+	Txid             []byte
+	SpaceEvent       string
+	Reason           string
+	Timestamp        int64
+	XactID           int64
+	Price            int64
+	Seller           string
+	Valid            bool
+	ListingHeight    int32
+	ListingTimestamp int64
+}
+
+type ListingsChange struct {
+	XactID    int64
+	ChangedAt int64
+}
+
+type ListingsVersion struct {
+	ID        bool
+	Version   int64
+	UpdatedAt int64
+}
+
+type PriceHistory struct {
+	ID        int64
 	Name      string
 	Price     int64
 	Seller    string
 	Signature []byte
+	Height    int32
 	Timestamp int64
+}
+
+type Sale struct {
+	ID        int64
+	Name      string
+	Price     int64
+	Seller    string
+	Buyer     string
+	Signature []byte
+	Txid      []byte
 	Height    int32
-	Valid     bool
+	Timestamp int64
+}
+
+type Space struct {
+	Name             string
+	OutpointTxid     []byte
+	OutpointN        int32
+	LastUpdateHeight int32
+	ExpireHeight     int32
+	LastEvent        string
+	UpdatedAt        int64
+	PendingTxid      []byte
 }
@@ -0,0 +1,80 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"log"
+
+	"github.com/spacesprotocol/marketplace/pkg/db"
+)
+
+type prevout struct {
+	Txid string `json:"txid"`
+	Vout int32  `json:"vout"`
+}
+
+type prevoutSpend struct {
+	Txid         string `json:"txid"`
+	Vout         int32  `json:"vout"`
+	SpendingTxid string `json:"spendingtxid"`
+}
+
+// getTxSpendingPrevout returns the mempool spends of the outpoints, in the
+// order of the outpoints
+func (bc *BitcoinClient) getTxSpendingPrevout(ctx context.Context, outpoints []prevout) ([]prevoutSpend, error) {
+	var spends []prevoutSpend
+	if err := bc.Rpc(ctx, "gettxspendingprevout", []interface{}{outpoints}, &spends); err != nil {
+		return nil, err
+	}
+	if len(spends) != len(outpoints) {
+		return nil, fmt.Errorf("gettxspendingprevout returned %d results for %d outpoints", len(spends), len(outpoints))
+	}
+	return spends, nil
+}
+
+// UpdatePending marks the listed spaces whose outpoint is spent by a mempool
+// transaction as pending, and clears the spaces whose spend has been mined or
+// evicted from the mempool.
+func UpdatePending(ctx context.Context, q *db.Queries, bc *BitcoinClient) error {
+	if bc == nil {
+		return nil
+	}
+	spaces, err := q.GetListedSpaceOutpoints(ctx)
+	if err != nil || len(spaces) == 0 {
+		return err
+	}
+
+	outpoints := make([]prevout, 0, len(spaces))
+	for _, space := range spaces {
+		outpoints = append(outpoints, prevout{Txid: hex.EncodeToString(space.OutpointTxid), Vout: space.OutpointN})
+	}
+	spends, err := bc.getTxSpendingPrevout(ctx, outpoints)
+	if err != nil {
+		return err
+	}
+
+	for i, space := range spaces {
+		pending := []byte{}
+		if spends[i].SpendingTxid != "" {
+			pending, err = hex.DecodeString(spends[i].SpendingTxid)
+			if err != nil {
+				return err
+			}
+		}
+		// only changes are written, they bump the cache version
+		if bytes.Equal(pending, space.PendingTxid) {
+			continue
+		}
+		if err := q.SetSpacePending(ctx, db.SetSpacePendingParams{Name: space.Name, PendingTxid: pending}); err != nil {
+			return err
+		}
+		if len(pending) > 0 {
+			log.Printf("space %s has a pending transfer in %x", space.Name, pending)
+		} else {
+			log.Printf("space %s has no pending transfer anymore", space.Name)
+		}
+	}
+	return nil
+}
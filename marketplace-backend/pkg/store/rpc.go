@@ -0,0 +1,98 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// RPCClient is a JSON-RPC client of a node. Unlike the node client it keeps
+// the code of the errors returned by the node, so that they can be told
+// apart, see RPCError.
+type RPCClient struct {
+	uri      string
+	user     string
+	password string
+	client   *http.Client
+}
+
+// RPCTimeout bounds every call to a node, a node which hangs fails the call
+// like an unreachable one so that the caller retries later
+var RPCTimeout = 30 * time.Second
+
+func NewRPCClient(uri, user, password string) *RPCClient {
+	return &RPCClient{uri: uri, user: user, password: password, client: &http.Client{Timeout: RPCTimeout}}
+}
+
+// RPCError is an error returned by the node in a JSON-RPC response
+type RPCError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *RPCError) Error() string {
+	return fmt.Sprintf("rpc error %d: %s", e.Code, e.Message)
+}
+
+type rpcRequest struct {
+	JSONRPC string        `json:"jsonrpc"`
+	ID      int           `json:"id"`
+	Method  string        `json:"method"`
+	Params  []interface{} `json:"params"`
+}
+
+type rpcResponse struct {
+	Result json.RawMessage `json:"result"`
+	Error  *RPCError       `json:"error"`
+}
+
+// Rpc calls method with params and decodes the result into result, which may
+// be nil. An error returned by the node is an *RPCError, any other error
+// means the node could not be asked.
+func (c *RPCClient) Rpc(ctx context.Context, method string, params []interface{}, result interface{}) error {
+	if params == nil {
+		params = []interface{}{}
+	}
+	body, err := json.Marshal(rpcRequest{JSONRPC: "2.0", ID: 1, Method: method, Params: params})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.uri, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.user != "" || c.password != "" {
+		req.SetBasicAuth(c.user, c.password)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	// bitcoin core answers errors with a 500 status and a JSON-RPC body
+	var response rpcResponse
+	if err := json.Unmarshal(data, &response); err != nil {
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("%s: unexpected status %s", method, resp.Status)
+		}
+		return fmt.Errorf("%s: %w", method, err)
+	}
+	if response.Error != nil {
+		return response.Error
+	}
+	if result == nil || len(response.Result) == 0 {
+		return nil
+	}
+	return json.Unmarshal(response.Result, result)
+}
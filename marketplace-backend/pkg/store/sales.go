@@ -0,0 +1,110 @@
+package store
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"math"
+	"strings"
+
+	"github.com/spacesprotocol/marketplace/pkg/db"
+)
+
+// BitcoinClient is an RPC client of a bitcoin core node. The spaces node only
+// reports the space outputs of a transaction, the payment of a filled listing
+// is looked up in the full transaction.
+type BitcoinClient struct {
+	*RPCClient
+}
+
+// rpcInvalidAddressOrKey is the bitcoin core error code of an unknown
+// transaction or block
+const rpcInvalidAddressOrKey = -5
+
+type rawTxOut struct {
+	Value        float64 `json:"value"`
+	N            int     `json:"n"`
+	ScriptPubKey struct {
+		Address string `json:"address"`
+	} `json:"scriptPubKey"`
+}
+
+type rawTx struct {
+	Txid string     `json:"txid"`
+	Vout []rawTxOut `json:"vout"`
+}
+
+// getRawTransaction returns the transaction txid of the block blockHash, nil
+// if the block does not contain it. Passing the block lets the node find a
+// confirmed transaction without -txindex.
+func (bc *BitcoinClient) getRawTransaction(ctx context.Context, txid []byte, blockHash []byte) (*rawTx, error) {
+	var tx rawTx
+	err := bc.Rpc(ctx, "getrawtransaction", []interface{}{hex.EncodeToString(txid), true, hex.EncodeToString(blockHash)}, &tx)
+	var rpcErr *RPCError
+	// an unknown block means the node is behind the spaces node, the block
+	// is retried later
+	if errors.As(err, &rpcErr) && rpcErr.Code == rpcInvalidAddressOrKey && !strings.HasPrefix(rpcErr.Message, "Block hash not found") {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &tx, nil
+}
+
+// FindSale checks whether the transaction txid of the block blockHash,
+// transferring a space to its output n, filled one of the space listings and returns the sale to record, nil if
+// none was filled. Only the bitcoin node is queried, the sale is written by
+// the caller.
+func FindSale(ctx context.Context, bc *BitcoinClient, listings []db.Listing, txid []byte, blockHash []byte, n int, height int32) (*db.InsertSaleParams, error) {
+	if bc == nil || len(listings) == 0 {
+		return nil, nil
+	}
+	tx, err := bc.getRawTransaction(ctx, txid, blockHash)
+	if err != nil || tx == nil {
+		return nil, err
+	}
+	filled, buyer := matchSale(tx, listings, n)
+	if filled == nil {
+		return nil, nil
+	}
+	return &db.InsertSaleParams{
+		Name:      filled.Name,
+		Price:     filled.Price,
+		Seller:    filled.Seller,
+		Buyer:     buyer,
+		Signature: filled.Signature,
+		Txid:      txid,
+		Height:    height,
+	}, nil
+}
+
+// matchSale returns the listing filled by the transaction and the buyer, the
+// address of the output n. A listing is filled when an output pays the listed
+// price to the seller and the space goes to someone else, when several are
+// filled the most expensive one is returned.
+func matchSale(tx *rawTx, listings []db.Listing, n int) (*db.Listing, string) {
+	if n < 0 || n >= len(tx.Vout) {
+		return nil, ""
+	}
+	buyer := tx.Vout[n].ScriptPubKey.Address
+
+	paid := map[string]int64{}
+	for _, out := range tx.Vout {
+		if out.N == n || out.ScriptPubKey.Address == "" {
+			continue
+		}
+		paid[out.ScriptPubKey.Address] += int64(math.Round(out.Value * 1e8))
+	}
+
+	var filled *db.Listing
+	for i, listing := range listings {
+		if !listing.Valid || listing.Seller == buyer || paid[listing.Seller] < listing.Price {
+			continue
+		}
+		if filled == nil || listing.Price > filled.Price {
+			filled = &listings[i]
+		}
+	}
+	return filled, buyer
+}
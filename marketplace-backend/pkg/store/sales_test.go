@@ -0,0 +1,99 @@
+package store
+
+import (
+	"testing"
+
+	"github.com/spacesprotocol/marketplace/pkg/db"
+)
+
+func testTx(outs ...rawTxOut) *rawTx {
+	for i := range outs {
+		outs[i].N = i
+	}
+	return &rawTx{Txid: "00", Vout: outs}
+}
+
+func testOut(address string, btc float64) rawTxOut {
+	out := rawTxOut{Value: btc}
+	out.ScriptPubKey.Address = address
+	return out
+}
+
+func TestMatchSale(t *testing.T) {
+	listings := []db.Listing{
+		{Name: "bob", Price: 100000, Seller: "seller1", Signature: []byte{1}, Valid: true},
+		{Name: "bob", Price: 250000, Seller: "seller2", Signature: []byte{2}, Valid: true},
+		{Name: "bob", Price: 50000, Seller: "seller3", Signature: []byte{3}, Valid: false},
+	}
+	tests := []struct {
+		name      string
+		tx        *rawTx
+		n         int
+		wantSig   byte
+		wantBuyer string
+	}{
+		{
+			name:    "exact price",
+			tx:      testTx(testOut("buyer", 0.00000662), testOut("seller1", 0.001)),
+			wantSig: 1, wantBuyer: "buyer",
+		},
+		{
+			name:    "overpaid",
+			tx:      testTx(testOut("buyer", 0.00000662), testOut("seller1", 0.0015)),
+			wantSig: 1, wantBuyer: "buyer",
+		},
+		{
+			name:      "underpaid",
+			tx:        testTx(testOut("buyer", 0.00000662), testOut("seller1", 0.00099999)),
+			wantBuyer: "buyer",
+		},
+		{
+			name:    "payment split over outputs",
+			tx:      testTx(testOut("buyer", 0.00000662), testOut("seller1", 0.0006), testOut("seller1", 0.0004)),
+			wantSig: 1, wantBuyer: "buyer",
+		},
+		{
+			name:    "most expensive filled listing",
+			tx:      testTx(testOut("seller1", 0.001), testOut("buyer", 0.00000662), testOut("seller2", 0.0025)),
+			n:       1,
+			wantSig: 2, wantBuyer: "buyer",
+		},
+		{
+			name:      "invalid listing",
+			tx:        testTx(testOut("buyer", 0.00000662), testOut("seller3", 0.001)),
+			wantBuyer: "buyer",
+		},
+		{
+			name:      "space back to the seller",
+			tx:        testTx(testOut("seller1", 0.00000662), testOut("seller1", 0.001)),
+			wantBuyer: "seller1",
+		},
+		{
+			name:      "space output not counted as payment",
+			tx:        testTx(testOut("seller1", 0.001)),
+			wantBuyer: "seller1",
+		},
+		{
+			name: "output out of range",
+			tx:   testTx(testOut("seller1", 0.001)),
+			n:    3,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filled, buyer := matchSale(tt.tx, listings, tt.n)
+			if buyer != tt.wantBuyer {
+				t.Errorf("buyer %q, want %q", buyer, tt.wantBuyer)
+			}
+			if tt.wantSig == 0 {
+				if filled != nil {
+					t.Errorf("filled listing %x, want none", filled.Signature)
+				}
+				return
+			}
+			if filled == nil || filled.Signature[0] != tt.wantSig {
+				t.Errorf("filled listing %v, want signature %x", filled, tt.wantSig)
+			}
+		})
+	}
+}
@@ -0,0 +1,119 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	"github.com/spacesprotocol/explorer-indexer/pkg/node"
+	"github.com/spacesprotocol/marketplace/pkg/db"
+)
+
+// Space events recorded as the last event of a space, see the spaces table.
+const (
+	SpaceEventCreate = "create"
+	SpaceEventUpdate = "update"
+	SpaceEventSpend  = "spend"
+)
+
+// Space events of the listing events recorded without a block touching the
+// space: the expiry of a listed space, the periodic revalidation of the
+// listings and the rollback of a reorganization.
+const (
+	SpaceEventExpire     = "expire"
+	SpaceEventRevalidate = "revalidate"
+	SpaceEventReorg      = "reorg"
+)
+
+// ErrSpaceExpired is the invalidation reason of the listings of an expired space
+var ErrSpaceExpired = errors.New("space expired")
+
+type spaceCovenant struct {
+	Type         string `json:"type"`
+	ExpireHeight int32  `json:"expire_height"`
+}
+
+type spaceOut struct {
+	Txid     node.Bytes    `json:"txid"`
+	N        int           `json:"n"`
+	Covenant spaceCovenant `json:"covenant"`
+}
+
+// getSpace returns the current state of a space, nil if it does not exist
+func getSpace(ctx context.Context, sc *SpacesClient, name string) (*spaceOut, error) {
+	var out *spaceOut
+	if err := sc.RPC.Rpc(ctx, "getspace", []interface{}{"@" + name}, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// getSpaceExpiry returns the expire height of a registered space, 0 if the
+// space is not registered or still in auction. The block meta does not carry
+// the covenant, so it is taken from the current state of the space.
+func getSpaceExpiry(ctx context.Context, sc *SpacesClient, name string) (int32, error) {
+	out, err := getSpace(ctx, sc, name)
+	if err != nil {
+		return 0, err
+	}
+	if out == nil || out.Covenant.Type != "transfer" {
+		return 0, nil
+	}
+	return out.Covenant.ExpireHeight, nil
+}
+
+// GetSpaceState returns the chain state to store for a space touched by the
+// transaction txid at height. n is the output holding the space after a
+// create or an update, it is ignored for a failed spend.
+func GetSpaceState(ctx context.Context, sc *SpacesClient, name string, txid []byte, n int, height int32, event string) (db.UpsertSpaceParams, error) {
+	expireHeight, err := getSpaceExpiry(ctx, sc, name)
+	if err != nil {
+		return db.UpsertSpaceParams{}, err
+	}
+	if event == SpaceEventSpend {
+		txid, n = []byte{}, -1
+	}
+	return db.UpsertSpaceParams{
+		Name:             name,
+		OutpointTxid:     txid,
+		OutpointN:        int32(n),
+		LastUpdateHeight: height,
+		ExpireHeight:     expireHeight,
+		LastEvent:        event,
+	}, nil
+}
+
+// LookupSpace returns the chain state to store for a listed space which no
+// indexed block touched, so that its expiry is known too
+func LookupSpace(ctx context.Context, sc *SpacesClient, name string) (db.UpsertSpaceParams, error) {
+	out, err := getSpace(ctx, sc, name)
+	if err != nil {
+		return db.UpsertSpaceParams{}, err
+	}
+	space := db.UpsertSpaceParams{Name: name, OutpointTxid: []byte{}, OutpointN: -1}
+	if out != nil {
+		space.OutpointTxid, space.OutpointN = out.Txid, int32(out.N)
+		if out.Covenant.Type == "transfer" {
+			space.ExpireHeight = out.Covenant.ExpireHeight
+		}
+	}
+	return space, nil
+}
+
+// InvalidateExpired invalidates the valid listings of the spaces expired at
+// height, as known from the spaces table
+func InvalidateExpired(ctx context.Context, q *db.Queries, height int32) error {
+	listings, err := q.GetExpiredValidListings(ctx, height)
+	if err != nil {
+		return err
+	}
+	for _, listing := range listings {
+		if err := ApplyVerification(ctx, q, listing, height, nil, SpaceEventExpire, ErrSpaceExpired); err != nil {
+			return err
+		}
+	}
+	if len(listings) > 0 {
+		log.Printf("invalidated %d listings of expired spaces at height %d", len(listings), height)
+	}
+	return nil
+}
@@ -1,46 +1,227 @@
-package main
+package store
 
 import (
 	"bytes"
 	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
 
-	"github.com/jackc/pgx"
 	"github.com/spacesprotocol/explorer-indexer/pkg/node"
 	"github.com/spacesprotocol/marketplace/pkg/db"
 )
 
-func GetSyncedHead(pg *pgx.Conn, sc *node.SpacesClient) (int32, *Bytes, error) {
-	q := db.New(pg)
+// Listing event types, see the listing_events table.
+const (
+	EventCreated     = "created"
+	EventRevalidated = "revalidated"
+	EventInvalidated = "invalidated"
+	EventSuperseded  = "superseded"
+)
+
+// SpacesClient is the client of the spaces node. Listings are verified with
+// a JSON-RPC client which keeps the error codes, so that a rejection of the
+// listing is told apart from a node which is starting, syncing or failing.
+// RejectionCodes are the error codes of verifylisting which reject the
+// listing, see DefaultRejectionCodes.
+type SpacesClient struct {
+	*node.SpacesClient
+	RPC            *RPCClient
+	RejectionCodes []int
+}
+
+func NewSpacesClient(uri, user, password string) *SpacesClient {
+	return &SpacesClient{
+		SpacesClient:   &node.SpacesClient{Client: node.NewClient(uri, user, password)},
+		RPC:            NewRPCClient(uri, user, password),
+		RejectionCodes: DefaultRejectionCodes,
+	}
+}
+
+// RejectionError is the error of a listing rejected by the spaces node, with
+// the reason given by the node
+type RejectionError struct {
+	Reason string
+}
+
+func (e *RejectionError) Error() string {
+	return e.Reason
+}
+
+// rpcInvalidParams is the JSON-RPC code of a request whose parameters the
+// node could not parse, for verifylisting the listing itself
+const rpcInvalidParams = -32602
+
+// DefaultRejectionCodes are the verifylisting error codes which reject the
+// listing unless VERIFY_REJECTION_CODES is set. Every other code, including
+// the generic -1 the node also returns for its internal and backend failures,
+// says nothing about the listing.
+var DefaultRejectionCodes = []int{rpcInvalidParams}
+
+// ParseRejectionCodes parses a comma separated list of error codes, see
+// VERIFY_REJECTION_CODES in env.example
+func ParseRejectionCodes(value string) ([]int, error) {
+	var codes []int
+	for _, field := range strings.Split(value, ",") {
+		code, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil {
+			return nil, fmt.Errorf("invalid rejection code %q", field)
+		}
+		codes = append(codes, code)
+	}
+	return codes, nil
+}
+
+// isRejection reports whether an error code of verifylisting rejects the
+// verified listing
+func (sc *SpacesClient) isRejection(code int) bool {
+	for _, rejection := range sc.RejectionCodes {
+		if code == rejection {
+			return true
+		}
+	}
+	return false
+}
+
+// ErrNodeUnavailable is wrapped by the verification errors which did not come
+// from the node, the validity of the listing is unknown and must not change
+var ErrNodeUnavailable = errors.New("spaces node unavailable")
+
+// VerifyListing asks the node whether a listing is valid. A rejection of the
+// node is returned as a *RejectionError, any other failure wraps
+// ErrNodeUnavailable.
+func (sc *SpacesClient) VerifyListing(ctx context.Context, listing node.Listing) error {
+	err := sc.RPC.Rpc(ctx, "verifylisting", []interface{}{listing}, nil)
+	if err == nil {
+		return nil
+	}
+	var rpcErr *RPCError
+	if errors.As(err, &rpcErr) && sc.isRejection(rpcErr.Code) {
+		return &RejectionError{Reason: rpcErr.Message}
+	}
+	return fmt.Errorf("%w: %v", ErrNodeUnavailable, err)
+}
+
+// VerifyListing asks the node whether a stored listing is still valid, see
+// SpacesClient.VerifyListing
+func VerifyListing(ctx context.Context, sc *SpacesClient, listing db.Listing) error {
+	sign := hex.EncodeToString(listing.Signature)
+	listingToCheck := node.Listing{Space: listing.Name, Seller: listing.Seller, Signature: sign, Price: int(listing.Price)}
+	listingToCheck.NormalizeSpace()
+	return sc.VerifyListing(ctx, listingToCheck)
+}
+
+// VerifyListings verifies the listings with at most concurrency requests to
+// the node at once. The result of each listing is at the same index as the
+// listing, see VerifyListing.
+func VerifyListings(ctx context.Context, sc *SpacesClient, listings []db.Listing, concurrency int) []error {
+	results := make([]error, len(listings))
+	sem := make(chan struct{}, max(concurrency, 1))
+	var wg sync.WaitGroup
+	for i := range listings {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			results[i] = VerifyListing(ctx, sc, listings[i])
+		}(i)
+	}
+	wg.Wait()
+	return results
+}
+
+// GetSyncedHead returns the height and hash of the highest block in the db
+// that is still part of the node's best chain. It walks back from the db tip
+// comparing block hashes against the node, so after a reorganization the
+// returned height is the fork point. Returns -1 if no common block is found.
+func GetSyncedHead(ctx context.Context, q *db.Queries, sc *SpacesClient) (int32, []byte, error) {
 	//takes last block from the DB
-	height, err := q.GetBlocksMaxHeight(context.Background())
+	height, err := q.GetBlocksMaxHeight(ctx)
 	if err != nil {
 		return -1, nil, err
 	}
 	//height is the height of the db block
 	for height >= 0 {
-		//take last block hash from the DB
-		dbHash, err := q.GetBlockHashByHeight(context.Background(), height)
+		//take block hash from the DB
+		dbHash, err := q.GetBlockHashByHeight(ctx, height)
 		if err != nil {
 			return -1, nil, err
 		}
-		//takes the block of same height from the bitcoin node
-		nodeHash, err := sc.GetBlockHash(context.Background(), int(height))
+		//takes the block of same height from the node
+		callCtx, cancel := context.WithTimeout(ctx, RPCTimeout)
+		nodeHash, err := sc.GetBlockHash(callCtx, int(height))
+		cancel()
 		if err != nil {
 			return -1, nil, err
 		}
-		// nodeHash *bytes
-		// dbHash Bytes
 		if bytes.Equal(dbHash, *nodeHash) {
-			//marking all the blocks in the DB after the sycned height as orphans
-			if err := q.SetOrphanAfterHeight(context.Background(), height); err != nil {
-				return -1, nil, err
-			}
-			if err := q.SetNegativeHeightToOrphans(context.Background()); err != nil {
-				return -1, nil, err
-			}
-			return height, &dbHash, nil
+			return height, dbHash, nil
 		}
+		log.Printf("block %d (%x) is not in the best chain anymore", height, dbHash)
 		height -= 1
 	}
 	return -1, nil, nil
 }
+
+// Rollback removes the blocks above height from the db and undoes the
+// listing validity changes made at those orphaned heights. listings are the
+// listings changed above height, see GetListingsAfterHeight, and verifyErrs
+// their verification against the node's current state, done by the caller
+// before the transaction. The new branch is then re-processed block by block
+// by the indexer.
+func Rollback(ctx context.Context, q *db.Queries, height int32, listings []db.Listing, verifyErrs []error) error {
+	if err := q.DeleteBlocksAfterHeight(ctx, height); err != nil {
+		return err
+	}
+	if err := q.DeleteSalesAfterHeight(ctx, height); err != nil {
+		return err
+	}
+	if err := q.DeleteSpacesAfterHeight(ctx, height); err != nil {
+		return err
+	}
+
+	for i, listing := range listings {
+		if err := ApplyVerification(ctx, q, listing, height+1, nil, SpaceEventReorg, verifyErrs[i]); err != nil {
+			return err
+		}
+	}
+
+	log.Printf("rolled back to height %d, rechecked %d listings", height, len(listings))
+	return nil
+}
+
+// ApplyVerification stores the verification result of a listing made at
+// height: a valid listing has height 0, an invalid one the height of its
+// invalidation. Only a change of validity is written and recorded as an
+// event, so that the change feed reproduces the listings and a listing which
+// stays invalid keeps its first invalidation. verifyErr is the error returned by
+// VerifyListing, its text is stored as the reason of an invalidation.
+func ApplyVerification(ctx context.Context, q *db.Queries, listing db.Listing, height int32, txid []byte, spaceEvent string, verifyErr error) error {
+	update := db.UpdateListingValidityAndHeightParams{Signature: listing.Signature, Valid: true}
+	if verifyErr != nil {
+		update.Valid = false
+		update.Height = height
+	}
+	changed, err := q.UpdateListingValidityAndHeight(ctx, update)
+	if err != nil || changed == 0 {
+		return err
+	}
+	event := db.InsertListingEventParams{
+		Signature:  listing.Signature,
+		Name:       listing.Name,
+		Event:      EventRevalidated,
+		Height:     height,
+		Txid:       txid,
+		SpaceEvent: spaceEvent,
+	}
+	if verifyErr != nil {
+		event.Event = EventInvalidated
+		event.Reason = verifyErr.Error()
+	}
+	return q.InsertListingEvent(ctx, event)
+}
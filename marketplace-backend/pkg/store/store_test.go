@@ -0,0 +1,236 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/spacesprotocol/explorer-indexer/pkg/node"
+	"github.com/spacesprotocol/marketplace/pkg/db"
+)
+
+func TestVerifyListingClassification(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     int
+		body       string
+		rejections []int
+		wantReason string
+		wantDown   bool
+	}{
+		{name: "valid", status: http.StatusOK, body: `{"result":null}`},
+		{name: "invalid params", status: http.StatusOK, body: `{"error":{"code":-32602,"message":"bad signature"}}`, wantReason: "bad signature"},
+		{name: "generic error", status: http.StatusOK, body: `{"error":{"code":-1,"message":"backend unavailable"}}`, wantDown: true},
+		{name: "configured code", status: http.StatusOK, body: `{"error":{"code":-1,"message":"space not found"}}`, rejections: []int{-1}, wantReason: "space not found"},
+		{name: "internal error", status: http.StatusOK, body: `{"error":{"code":-32603,"message":"internal"}}`, wantDown: true},
+		{name: "warming up", status: http.StatusOK, body: `{"error":{"code":-28,"message":"loading"}}`, wantDown: true},
+		{name: "unknown method", status: http.StatusOK, body: `{"error":{"code":-32601,"message":"method not found"}}`, wantDown: true},
+		{name: "server error", status: http.StatusBadGateway, body: "bad gateway", wantDown: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(tt.status)
+				fmt.Fprint(w, tt.body)
+			}))
+			defer srv.Close()
+			sc := NewSpacesClient(srv.URL, "", "")
+			if tt.rejections != nil {
+				sc.RejectionCodes = tt.rejections
+			}
+
+			err := sc.VerifyListing(context.Background(), node.Listing{})
+			var rejection *RejectionError
+			switch {
+			case tt.wantReason != "":
+				if !errors.As(err, &rejection) || rejection.Reason != tt.wantReason {
+					t.Errorf("error %v, want rejection %q", err, tt.wantReason)
+				}
+			case tt.wantDown:
+				if !errors.Is(err, ErrNodeUnavailable) {
+					t.Errorf("error %v, want node unavailable", err)
+				}
+			default:
+				if err != nil {
+					t.Errorf("error %v, want none", err)
+				}
+			}
+		})
+	}
+}
+
+func TestVerifyListingTimeout(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		<-release
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	sc := NewSpacesClient(srv.URL, "", "")
+	sc.RPC.client.Timeout = 50 * time.Millisecond
+	if err := sc.VerifyListing(context.Background(), node.Listing{}); !errors.Is(err, ErrNodeUnavailable) {
+		t.Errorf("error %v, want node unavailable", err)
+	}
+}
+
+func TestParseRejectionCodes(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    []int
+		wantErr bool
+	}{
+		{value: "-32602", want: []int{-32602}},
+		{value: "-32602, -1", want: []int{-32602, -1}},
+		{value: "-32602,", wantErr: true},
+		{value: "invalid", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := ParseRejectionCodes(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error %v, want error %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("codes %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// fakeDB keeps the validity of the listings in memory and records the other
+// statements, it runs the queries used by ApplyVerification and Rollback
+type fakeDB struct {
+	listings map[string]*db.Listing
+	events   []db.InsertListingEventParams
+	execs    []string
+}
+
+func newFakeDB(listings ...db.Listing) *fakeDB {
+	f := &fakeDB{listings: map[string]*db.Listing{}}
+	for i := range listings {
+		f.listings[string(listings[i].Signature)] = &listings[i]
+	}
+	return f
+}
+
+func (f *fakeDB) Exec(_ context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error) {
+	name := strings.Fields(strings.TrimPrefix(sql, "-- name: "))[0]
+	switch name {
+	case "UpdateListingValidityAndHeight":
+		listing := f.listings[string(args[0].([]byte))]
+		if listing == nil || listing.Valid == args[1].(bool) {
+			return pgconn.NewCommandTag("UPDATE 0"), nil
+		}
+		listing.Valid, listing.Height = args[1].(bool), args[2].(int32)
+		return pgconn.NewCommandTag("UPDATE 1"), nil
+	case "InsertListingEvent":
+		f.events = append(f.events, db.InsertListingEventParams{
+			Signature: args[0].([]byte), Name: args[1].(string), Event: args[2].(string), Height: args[3].(int32),
+			Txid: args[4].([]byte), SpaceEvent: args[5].(string), Reason: args[6].(string),
+		})
+		return pgconn.NewCommandTag("INSERT 0 1"), nil
+	}
+	f.execs = append(f.execs, fmt.Sprintf("%s %v", name, args))
+	return pgconn.NewCommandTag(""), nil
+}
+
+func (f *fakeDB) Query(context.Context, string, ...interface{}) (pgx.Rows, error) {
+	panic("unexpected query")
+}
+
+func (f *fakeDB) QueryRow(context.Context, string, ...interface{}) pgx.Row {
+	panic("unexpected query")
+}
+
+func TestApplyVerification(t *testing.T) {
+	rejected := &RejectionError{Reason: "space spent"}
+	tests := []struct {
+		name       string
+		listing    db.Listing
+		verifyErr  error
+		wantValid  bool
+		wantHeight int32
+		wantEvent  string
+	}{
+		{name: "still valid", listing: db.Listing{Valid: true}, wantValid: true},
+		{name: "invalidated", listing: db.Listing{Valid: true}, verifyErr: rejected, wantHeight: 120, wantEvent: EventInvalidated},
+		{name: "still invalid keeps its height", listing: db.Listing{Height: 100}, verifyErr: rejected, wantHeight: 100},
+		{name: "revalidated", listing: db.Listing{Height: 100}, wantValid: true, wantEvent: EventRevalidated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.listing.Name, tt.listing.Signature = "bob", []byte{1}
+			fake := newFakeDB(tt.listing)
+			if err := ApplyVerification(context.Background(), db.New(fake), tt.listing, 120, []byte{2}, SpaceEventSpend, tt.verifyErr); err != nil {
+				t.Fatalf("error %v", err)
+			}
+			stored := fake.listings[string(tt.listing.Signature)]
+			if stored.Valid != tt.wantValid || stored.Height != tt.wantHeight {
+				t.Errorf("stored valid %v at height %d, want %v at %d", stored.Valid, stored.Height, tt.wantValid, tt.wantHeight)
+			}
+			if tt.wantEvent == "" {
+				if len(fake.events) != 0 {
+					t.Errorf("events %+v, want none", fake.events)
+				}
+				return
+			}
+			if len(fake.events) != 1 || fake.events[0].Event != tt.wantEvent || fake.events[0].Height != 120 {
+				t.Fatalf("events %+v, want one %s at height 120", fake.events, tt.wantEvent)
+			}
+			if tt.verifyErr != nil && fake.events[0].Reason != tt.verifyErr.Error() {
+				t.Errorf("reason %q, want %q", fake.events[0].Reason, tt.verifyErr.Error())
+			}
+		})
+	}
+}
+
+func TestRollback(t *testing.T) {
+	listings := []db.Listing{
+		{Name: "bob", Signature: []byte{1}, Valid: false, Height: 105},
+		{Name: "alice", Signature: []byte{2}, Valid: true},
+		{Name: "carol", Signature: []byte{3}, Valid: false, Height: 103},
+	}
+	// bob was invalidated on the orphaned branch only, alice is invalidated
+	// by the new branch and carol stays invalid
+	verifyErrs := []error{nil, &RejectionError{Reason: "space spent"}, &RejectionError{Reason: "space expired"}}
+	fake := newFakeDB(listings...)
+
+	if err := Rollback(context.Background(), db.New(fake), 100, listings, verifyErrs); err != nil {
+		t.Fatalf("error %v", err)
+	}
+
+	wantExecs := []string{"DeleteBlocksAfterHeight [100]", "DeleteSalesAfterHeight [100]", "DeleteSpacesAfterHeight [100]"}
+	if !reflect.DeepEqual(fake.execs, wantExecs) {
+		t.Errorf("statements %v, want %v", fake.execs, wantExecs)
+	}
+	want := map[string]struct {
+		valid  bool
+		height int32
+	}{
+		"bob":   {valid: true, height: 0},
+		"alice": {valid: false, height: 101},
+		"carol": {valid: false, height: 103},
+	}
+	for _, listing := range fake.listings {
+		if w := want[listing.Name]; listing.Valid != w.valid || listing.Height != w.height {
+			t.Errorf("%s valid %v at height %d, want %v at %d", listing.Name, listing.Valid, listing.Height, w.valid, w.height)
+		}
+	}
+	if len(fake.events) != 2 {
+		t.Fatalf("events %+v, want 2", fake.events)
+	}
+	for _, event := range fake.events {
+		if event.SpaceEvent != SpaceEventReorg || event.Height != 101 {
+			t.Errorf("event %+v, want a reorg at height 101", event)
+		}
+	}
+}
@@ -28,3 +28,14 @@ WITH latest_block AS (
 SELECT
     COALESCE((SELECT height FROM latest_block), -2)::integer as height,
     COALESCE((SELECT hash FROM latest_block), '\x')::bytea as hash;
+
+
+-- name: GetBlockHashByHeight :one
+SELECT hash
+FROM blocks
+WHERE height = $1;
+
+
+-- name: DeleteBlocksAfterHeight :exec
+DELETE FROM blocks
+WHERE height > $1;
@@ -0,0 +1,18 @@
+-- name: CompactListingsChanges :exec
+-- folds the committed change rows into listings_version, the served version
+-- is the same before and after
+WITH compacted AS (
+    DELETE FROM listings_changes
+    RETURNING changed_at
+)
+UPDATE listings_version
+SET version = version + (SELECT count(*) FROM compacted),
+    updated_at = greatest(updated_at, (SELECT COALESCE(max(changed_at), 0) FROM compacted));
+
+-- name: GetCacheVersion :one
+SELECT
+    COALESCE((SELECT height FROM blocks ORDER BY height DESC LIMIT 1), -1)::integer AS height,
+    COALESCE((SELECT hash FROM blocks ORDER BY height DESC LIMIT 1), '\x')::bytea AS hash,
+    (version + (SELECT count(*) FROM listings_changes))::bigint AS version,
+    greatest(updated_at, (SELECT COALESCE(max(changed_at), 0) FROM listings_changes))::bigint AS updated_at
+FROM listings_version;
@@ -0,0 +1,29 @@
+-- name: InsertListingEvent :exec
+INSERT INTO listing_events (
+    signature,
+    name,
+    event,
+    height,
+    txid,
+    space_event,
+    reason
+)
+VALUES ($1, $2, $3, $4, $5, $6, $7);
+
+
+-- name: GetListingEventsByName :many
+SELECT *
+FROM listing_events
+WHERE name = $1
+ORDER BY id DESC
+limit sqlc.arg('limit')
+OFFSET sqlc.arg('offset');
+
+
+-- name: GetListingChanges :many
+SELECT *
+FROM listing_events
+WHERE (xact_id, id) > (sqlc.arg('after_xact_id')::bigint, sqlc.arg('after_id')::bigint)
+  AND xact_id < pg_snapshot_xmin(pg_current_snapshot())::text::bigint
+ORDER BY xact_id, id
+limit sqlc.arg('limit');
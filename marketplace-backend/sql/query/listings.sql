@@ -5,16 +5,18 @@ INSERT INTO listings (
     seller,
     signature,
     height,
-    valid
+    valid,
+    last_verified_at
 )
-VALUES ($1, $2, $3, $4, $5, $6)
+VALUES ($1, $2, $3, $4, $5, $6, $7)
 ON CONFLICT (signature)
 DO UPDATE SET
         name = EXCLUDED.name,
         price = EXCLUDED.price,
         seller = EXCLUDED.seller,
         height = EXCLUDED.height,
-        valid = EXCLUDED.valid;
+        valid = EXCLUDED.valid,
+        last_verified_at = EXCLUDED.last_verified_at;
 
 
 -- name: InsertListing :exec
@@ -29,6 +31,8 @@ VALUES ($1, $2, $3, $4, $5);
 
 
 -- name: GetLatestListings :many
+-- the height filters apply to the block height at which the listing was
+-- posted, the height of its created event
 WITH RankedListings AS (
   SELECT DISTINCT ON (name)
     name,
@@ -39,31 +43,155 @@ WITH RankedListings AS (
     height
   FROM listings
   WHERE valid = true
+    AND (
+      sqlc.arg('search')::text = ''
+      OR (sqlc.arg('search_mode')::text IN ('any', 'prefix') AND name LIKE sqlc.arg('search_like')::text || '%')
+      OR (sqlc.arg('search_mode')::text IN ('any', 'substring') AND name LIKE '%' || sqlc.arg('search_like')::text || '%')
+      OR (sqlc.arg('search_mode')::text IN ('any', 'fuzzy') AND name % sqlc.arg('search')::text)
+    )
+    AND (sqlc.arg('min_price')::bigint < 0 OR price >= sqlc.arg('min_price')::bigint)
+    AND (sqlc.arg('max_price')::bigint < 0 OR price <= sqlc.arg('max_price')::bigint)
+    AND (sqlc.arg('min_length')::integer < 0 OR char_length(name) >= sqlc.arg('min_length')::integer)
+    AND (sqlc.arg('max_length')::integer < 0 OR char_length(name) <= sqlc.arg('max_length')::integer)
+    AND (
+      sqlc.arg('charset')::text = ''
+      OR (sqlc.arg('charset')::text = 'digits' AND name ~ '^[0-9]+$')
+      OR (sqlc.arg('charset')::text = 'letters' AND name ~ '^[a-z]+$')
+      OR (sqlc.arg('charset')::text = 'idn' AND name LIKE 'xn--%')
+    )
+    AND (sqlc.arg('seller')::text = '' OR seller = sqlc.arg('seller')::text)
+    AND (sqlc.arg('min_height')::integer < 0 OR (SELECT min(e.height) FROM listing_events e WHERE e.signature = listings.signature AND e.event = 'created') >= sqlc.arg('min_height')::integer)
+    AND (sqlc.arg('max_height')::integer < 0 OR (SELECT min(e.height) FROM listing_events e WHERE e.signature = listings.signature AND e.event = 'created') <= sqlc.arg('max_height')::integer)
   ORDER BY name, price ASC
 )
-SELECT *
+SELECT *, similarity(name, sqlc.arg('search')::text)::real AS relevance
 FROM RankedListings
+WHERE sqlc.arg('after_name')::text = ''
+  OR (sqlc.arg('sort_by')::text = 'price' AND sqlc.arg('sort_order')::text = 'asc' AND (price, name) > (sqlc.arg('after_key')::bigint, sqlc.arg('after_name')::text))
+  OR (sqlc.arg('sort_by')::text = 'price' AND sqlc.arg('sort_order')::text = 'desc' AND (price < sqlc.arg('after_key')::bigint OR (price = sqlc.arg('after_key')::bigint AND name > sqlc.arg('after_name')::text)))
+  OR (sqlc.arg('sort_by')::text = 'timestamp' AND sqlc.arg('sort_order')::text = 'asc' AND (timestamp, name) > (sqlc.arg('after_key')::bigint, sqlc.arg('after_name')::text))
+  OR (sqlc.arg('sort_by')::text = 'timestamp' AND sqlc.arg('sort_order')::text = 'desc' AND (timestamp < sqlc.arg('after_key')::bigint OR (timestamp = sqlc.arg('after_key')::bigint AND name > sqlc.arg('after_name')::text)))
+  -- the relevance of the last name is computed again, the rank is negated to
+  -- compare the descending relevance and the ascending name as one tuple
+  OR (sqlc.arg('sort_by')::text = 'relevance'
+    AND (name <> sqlc.arg('search')::text, name NOT LIKE sqlc.arg('search_like')::text || '%', -similarity(name, sqlc.arg('search')::text), name)
+      > (sqlc.arg('after_name')::text <> sqlc.arg('search')::text, sqlc.arg('after_name')::text NOT LIKE sqlc.arg('search_like')::text || '%', -similarity(sqlc.arg('after_name')::text, sqlc.arg('search')::text), sqlc.arg('after_name')::text))
 ORDER BY
+  CASE WHEN sqlc.arg('sort_by')::text = 'relevance' THEN name = sqlc.arg('search')::text END DESC,
+  CASE WHEN sqlc.arg('sort_by')::text = 'relevance' THEN name LIKE sqlc.arg('search_like')::text || '%' END DESC,
+  CASE WHEN sqlc.arg('sort_by')::text = 'relevance' THEN similarity(name, sqlc.arg('search')::text) END DESC,
   CASE WHEN sqlc.arg('sort_by')::text = 'price' AND sqlc.arg('sort_order')::text = 'asc' THEN price END ASC,
   CASE WHEN sqlc.arg('sort_by')::text = 'price' AND sqlc.arg('sort_order')::text = 'desc' THEN price END DESC,
   CASE WHEN sqlc.arg('sort_by')::text = 'timestamp' AND sqlc.arg('sort_order')::text = 'asc' THEN timestamp END ASC,
-  CASE WHEN sqlc.arg('sort_by')::text = 'timestamp' AND sqlc.arg('sort_order')::text = 'desc' THEN timestamp END DESC
+  CASE WHEN sqlc.arg('sort_by')::text = 'timestamp' AND sqlc.arg('sort_order')::text = 'desc' THEN timestamp END DESC,
+  name
 limit sqlc.arg('limit')
 OFFSET sqlc.arg('offset');
 
 
+-- name: CountLatestListings :one
+SELECT COUNT(DISTINCT name)
+FROM listings
+WHERE valid = true
+  AND (
+    sqlc.arg('search')::text = ''
+    OR (sqlc.arg('search_mode')::text IN ('any', 'prefix') AND name LIKE sqlc.arg('search_like')::text || '%')
+    OR (sqlc.arg('search_mode')::text IN ('any', 'substring') AND name LIKE '%' || sqlc.arg('search_like')::text || '%')
+    OR (sqlc.arg('search_mode')::text IN ('any', 'fuzzy') AND name % sqlc.arg('search')::text)
+  )
+  AND (sqlc.arg('min_price')::bigint < 0 OR price >= sqlc.arg('min_price')::bigint)
+  AND (sqlc.arg('max_price')::bigint < 0 OR price <= sqlc.arg('max_price')::bigint)
+  AND (sqlc.arg('min_length')::integer < 0 OR char_length(name) >= sqlc.arg('min_length')::integer)
+  AND (sqlc.arg('max_length')::integer < 0 OR char_length(name) <= sqlc.arg('max_length')::integer)
+  AND (
+    sqlc.arg('charset')::text = ''
+    OR (sqlc.arg('charset')::text = 'digits' AND name ~ '^[0-9]+$')
+    OR (sqlc.arg('charset')::text = 'letters' AND name ~ '^[a-z]+$')
+    OR (sqlc.arg('charset')::text = 'idn' AND name LIKE 'xn--%')
+  )
+  AND (sqlc.arg('seller')::text = '' OR seller = sqlc.arg('seller')::text)
+  AND (sqlc.arg('min_height')::integer < 0 OR (SELECT min(e.height) FROM listing_events e WHERE e.signature = listings.signature AND e.event = 'created') >= sqlc.arg('min_height')::integer)
+  AND (sqlc.arg('max_height')::integer < 0 OR (SELECT min(e.height) FROM listing_events e WHERE e.signature = listings.signature AND e.event = 'created') <= sqlc.arg('max_height')::integer);
+
+
 -- name: GetListingByName :many
 SELECT * 
 FROM listings
 WHERE name = $1 order by price asc;
 
+-- name: GetVerifiableListingsByName :many
+-- the listings of a space whose validity follows the node, all but the
+-- superseded ones
+SELECT *
+FROM listings
+WHERE name = $1
+  AND coalesce((SELECT e.event FROM listing_events e WHERE e.signature = listings.signature ORDER BY e.id DESC LIMIT 1), '') <> 'superseded'
+ORDER BY price ASC;
+
 -- name: GetValidListingByName :many
 SELECT * 
 FROM listings
 WHERE name = $1 and valid = true order by price asc limit 1;
 
 
--- name: UpdateListingValidityAndHeight :exec
+-- name: UpdateListingValidityAndHeight :execrows
+-- only a change of validity is written, the caller records an event for it.
+-- The height of a listing which stays invalid is the one of its invalidation.
 UPDATE listings
 SET valid = $2, height = $3
+WHERE signature = $1
+  AND valid <> $2;
+
+
+-- name: GetListingsAfterHeight :many
+-- the listings changed by a block above height: revalidated listings are
+-- stored with height 0, their events keep the height of the change. The
+-- superseded listings are left out, they stay invalid whatever the node says.
+SELECT *
+FROM listings
+WHERE (height > $1
+   OR signature IN (SELECT signature FROM listing_events WHERE listing_events.height > $1))
+  AND coalesce((SELECT e.event FROM listing_events e WHERE e.signature = listings.signature ORDER BY e.id DESC LIMIT 1), '') <> 'superseded';
+
+
+-- name: GetListingBySignature :many
+SELECT *
+FROM listings
 WHERE signature = $1;
+
+
+-- name: GetListingsBySeller :many
+SELECT *
+FROM listings
+WHERE seller = sqlc.arg('seller')
+  AND (sqlc.arg('status')::text = 'all' OR valid = (sqlc.arg('status')::text = 'valid'))
+ORDER BY
+  valid DESC,
+  CASE WHEN sqlc.arg('sort_by')::text = 'price' AND sqlc.arg('sort_order')::text = 'asc' THEN price END ASC,
+  CASE WHEN sqlc.arg('sort_by')::text = 'price' AND sqlc.arg('sort_order')::text = 'desc' THEN price END DESC,
+  CASE WHEN sqlc.arg('sort_by')::text = 'timestamp' AND sqlc.arg('sort_order')::text = 'asc' THEN timestamp END ASC,
+  CASE WHEN sqlc.arg('sort_by')::text = 'timestamp' AND sqlc.arg('sort_order')::text = 'desc' THEN timestamp END DESC,
+  name
+limit sqlc.arg('limit')
+OFFSET sqlc.arg('offset');
+
+
+-- name: GetValidListingsByNames :many
+SELECT DISTINCT ON (name) *
+FROM listings
+WHERE name = ANY(sqlc.arg('names')::text[]) AND valid = true
+ORDER BY name, price ASC;
+
+
+-- name: GetListingsToRevalidate :many
+SELECT *
+FROM listings
+WHERE valid = true AND last_verified_at < sqlc.arg('verified_before')::bigint
+ORDER BY last_verified_at, signature
+limit sqlc.arg('limit');
+
+
+-- name: SetListingsVerifiedAt :exec
+UPDATE listings
+SET last_verified_at = sqlc.arg('verified_at')::bigint
+WHERE signature = ANY(sqlc.arg('signatures')::bytea[]);
@@ -0,0 +1,14 @@
+-- name: GetPriceHistoryBuckets :many
+SELECT
+  EXTRACT(EPOCH FROM date_trunc(sqlc.arg('interval')::text, to_timestamp(timestamp) AT TIME ZONE 'UTC'))::bigint AS bucket,
+  (array_agg(price ORDER BY id ASC))[1]::bigint AS open,
+  MAX(price)::bigint AS high,
+  MIN(price)::bigint AS low,
+  (array_agg(price ORDER BY id DESC))[1]::bigint AS close,
+  COUNT(*)::bigint AS count
+FROM price_history
+WHERE name = sqlc.arg('name')
+  AND timestamp >= sqlc.arg('since')::bigint
+GROUP BY bucket
+ORDER BY bucket DESC
+limit sqlc.arg('limit');
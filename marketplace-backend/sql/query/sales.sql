@@ -0,0 +1,34 @@
+-- name: InsertSale :exec
+INSERT INTO sales (
+    name,
+    price,
+    seller,
+    buyer,
+    signature,
+    txid,
+    height
+)
+VALUES ($1, $2, $3, $4, $5, $6, $7)
+ON CONFLICT (txid, name) DO NOTHING;
+
+
+-- name: GetSales :many
+SELECT *
+FROM sales
+ORDER BY height DESC, id DESC
+limit sqlc.arg('limit')
+OFFSET sqlc.arg('offset');
+
+
+-- name: GetSalesByName :many
+SELECT *
+FROM sales
+WHERE name = $1
+ORDER BY height DESC, id DESC
+limit sqlc.arg('limit')
+OFFSET sqlc.arg('offset');
+
+
+-- name: DeleteSalesAfterHeight :exec
+DELETE FROM sales
+WHERE height > $1;
@@ -0,0 +1,60 @@
+-- name: UpsertSpace :exec
+-- a failed spend does not move the space, its outpoint is kept
+INSERT INTO spaces (
+    name,
+    outpoint_txid,
+    outpoint_n,
+    last_update_height,
+    expire_height,
+    last_event
+)
+VALUES ($1, $2, $3, $4, $5, $6)
+ON CONFLICT (name)
+DO UPDATE SET
+        outpoint_txid = CASE WHEN EXCLUDED.last_event = 'spend' THEN spaces.outpoint_txid ELSE EXCLUDED.outpoint_txid END,
+        outpoint_n = CASE WHEN EXCLUDED.last_event = 'spend' THEN spaces.outpoint_n ELSE EXCLUDED.outpoint_n END,
+        last_update_height = EXCLUDED.last_update_height,
+        expire_height = CASE WHEN EXCLUDED.expire_height > 0 THEN EXCLUDED.expire_height ELSE spaces.expire_height END,
+        last_event = EXCLUDED.last_event,
+        pending_txid = ''::bytea,
+        updated_at = EXTRACT(EPOCH FROM NOW())::BIGINT;
+
+
+-- name: GetSpacesByNames :many
+SELECT *
+FROM spaces
+WHERE name = ANY(sqlc.arg('names')::text[]);
+
+
+-- name: DeleteSpacesAfterHeight :exec
+DELETE FROM spaces
+WHERE last_update_height > $1;
+
+
+-- name: GetListedNamesWithoutSpace :many
+SELECT DISTINCT listings.name
+FROM listings
+LEFT JOIN spaces ON spaces.name = listings.name
+WHERE listings.valid = true AND spaces.name IS NULL;
+
+
+-- name: GetExpiredValidListings :many
+SELECT listings.*
+FROM listings
+JOIN spaces ON spaces.name = listings.name
+WHERE listings.valid = true
+  AND spaces.expire_height > 0
+  AND spaces.expire_height <= $1;
+
+
+-- name: GetListedSpaceOutpoints :many
+SELECT name, outpoint_txid, outpoint_n, pending_txid
+FROM spaces
+WHERE outpoint_n >= 0
+  AND EXISTS (SELECT 1 FROM listings WHERE listings.name = spaces.name AND listings.valid = true);
+
+
+-- name: SetSpacePending :exec
+UPDATE spaces
+SET pending_txid = $2
+WHERE name = $1;
@@ -0,0 +1,57 @@
+-- name: GetListingStats :one
+WITH best AS (
+  SELECT DISTINCT ON (name) name, price
+  FROM listings
+  WHERE valid = true
+  ORDER BY name, price ASC
+)
+SELECT
+  (SELECT COUNT(*) FROM listings WHERE valid = true)::bigint AS valid_listings,
+  COUNT(*)::bigint AS listed_spaces,
+  COALESCE(MIN(price), 0)::bigint AS floor_price,
+  COALESCE(percentile_cont(0.5) WITHIN GROUP (ORDER BY price), 0)::float8 AS median_price,
+  COALESCE(AVG(price), 0)::float8 AS mean_price
+FROM best;
+
+
+-- name: GetPriceHistogram :many
+WITH best AS (
+  SELECT DISTINCT ON (name) name, price
+  FROM listings
+  WHERE valid = true
+  ORDER BY name, price ASC
+)
+SELECT
+  floor(log(greatest(price, 1)::float8))::integer AS magnitude,
+  COUNT(*)::bigint AS count
+FROM best
+GROUP BY magnitude
+ORDER BY magnitude;
+
+
+-- name: GetListingsByNameLength :many
+WITH best AS (
+  SELECT DISTINCT ON (name) name, price
+  FROM listings
+  WHERE valid = true
+  ORDER BY name, price ASC
+)
+SELECT
+  char_length(name)::integer AS length,
+  COUNT(*)::bigint AS count
+FROM best
+GROUP BY length
+ORDER BY length;
+
+
+-- name: GetActivityStats :one
+-- the invalidations are counted from their events, a listing may have been
+-- revalidated since
+SELECT
+  COUNT(*) FILTER (WHERE timestamp >= sqlc.arg('since_day')::bigint)::bigint AS new_day,
+  COUNT(*) FILTER (WHERE timestamp >= sqlc.arg('since_week')::bigint)::bigint AS new_week,
+  COUNT(*) FILTER (WHERE timestamp >= sqlc.arg('since_month')::bigint)::bigint AS new_month,
+  (SELECT COUNT(DISTINCT signature) FROM listing_events WHERE event = 'invalidated' AND height >= sqlc.arg('height_day')::integer)::bigint AS invalidated_day,
+  (SELECT COUNT(DISTINCT signature) FROM listing_events WHERE event = 'invalidated' AND height >= sqlc.arg('height_week')::integer)::bigint AS invalidated_week,
+  (SELECT COUNT(DISTINCT signature) FROM listing_events WHERE event = 'invalidated' AND height >= sqlc.arg('height_month')::integer)::bigint AS invalidated_month
+FROM listings;
@@ -0,0 +1,26 @@
+-- +goose Up
+-- +goose StatementBegin
+create table listing_events(
+      id bigserial PRIMARY KEY,
+      signature bytea not null references listings(signature) on delete cascade,
+      name varchar(63) not null,
+      event varchar(16) not null check (event in ('created', 'revalidated', 'invalidated', 'superseded')),
+      height integer not null,
+      txid bytea,
+      space_event varchar(16) not null default '',
+      reason text not null default '',
+      timestamp BIGINT NOT NULL DEFAULT EXTRACT(EPOCH FROM NOW())::BIGINT
+);
+
+
+CREATE INDEX listing_events_index_signature ON listing_events(signature);
+CREATE INDEX listing_events_index_name ON listing_events(name);
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP index listing_events_index_signature;
+DROP index listing_events_index_name;
+DROP table listing_events;
+-- +goose StatementEnd
@@ -0,0 +1,12 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+CREATE INDEX listings_index_name_trgm ON listings USING gin (name gin_trgm_ops);
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP index listings_index_name_trgm;
+-- +goose StatementEnd
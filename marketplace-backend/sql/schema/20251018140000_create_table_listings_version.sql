@@ -0,0 +1,36 @@
+-- +goose Up
+-- +goose StatementBegin
+create table listings_version(
+      id boolean PRIMARY KEY DEFAULT true CHECK (id),
+      version bigint not null default 0,
+      updated_at BIGINT NOT NULL DEFAULT EXTRACT(EPOCH FROM NOW())::BIGINT
+);
+
+insert into listings_version default values;
+
+create function bump_listings_version() returns trigger as $$
+begin
+      update listings_version
+      set version = version + 1,
+          updated_at = EXTRACT(EPOCH FROM NOW())::BIGINT;
+      return null;
+end;
+$$ language plpgsql;
+
+create trigger listings_bump_version
+after insert or update or delete on listings
+for each statement execute function bump_listings_version();
+
+create trigger listing_events_bump_version
+after insert or update or delete on listing_events
+for each statement execute function bump_listings_version();
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP trigger listing_events_bump_version on listing_events;
+DROP trigger listings_bump_version on listings;
+DROP function bump_listings_version;
+DROP table listings_version;
+-- +goose StatementEnd
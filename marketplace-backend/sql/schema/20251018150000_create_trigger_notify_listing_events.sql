@@ -0,0 +1,36 @@
+-- +goose Up
+-- +goose StatementBegin
+create function notify_listing_event() returns trigger as $$
+declare
+      listing listings%rowtype;
+begin
+      select * into listing from listings where signature = NEW.signature;
+      perform pg_notify('listing_events', json_build_object(
+            'id', NEW.id,
+            'event', NEW.event,
+            'space', NEW.name,
+            'signature', encode(NEW.signature, 'hex'),
+            'price', listing.price,
+            'seller', listing.seller,
+            'valid', listing.valid,
+            'height', NEW.height,
+            'txid', encode(NEW.txid, 'hex'),
+            'space_event', NEW.space_event,
+            'reason', left(NEW.reason, 1000),
+            'timestamp', NEW.timestamp
+      )::text);
+      return null;
+end;
+$$ language plpgsql;
+
+create trigger listing_events_notify
+after insert on listing_events
+for each row execute function notify_listing_event();
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP trigger listing_events_notify on listing_events;
+DROP function notify_listing_event;
+-- +goose StatementEnd
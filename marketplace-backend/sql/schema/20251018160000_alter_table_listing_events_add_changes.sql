@@ -0,0 +1,41 @@
+-- +goose Up
+-- +goose StatementBegin
+alter table listing_events
+      add column xact_id bigint not null default pg_current_xact_id()::text::bigint,
+      add column price bigint not null default 0,
+      add column seller varchar(150) not null default '',
+      add column valid boolean not null default false;
+
+update listing_events e
+set price = l.price, seller = l.seller, valid = l.valid
+from listings l
+where l.signature = e.signature;
+
+-- events keep the state of the listing right after the change
+create function snapshot_listing_event() returns trigger as $$
+begin
+      select price, seller, valid into NEW.price, NEW.seller, NEW.valid
+      from listings where signature = NEW.signature;
+      return NEW;
+end;
+$$ language plpgsql;
+
+create trigger listing_events_snapshot
+before insert on listing_events
+for each row execute function snapshot_listing_event();
+
+CREATE INDEX listing_events_index_xact_id ON listing_events(xact_id, id);
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP index listing_events_index_xact_id;
+DROP trigger listing_events_snapshot on listing_events;
+DROP function snapshot_listing_event;
+alter table listing_events
+      drop column xact_id,
+      drop column price,
+      drop column seller,
+      drop column valid;
+-- +goose StatementEnd
@@ -0,0 +1,46 @@
+-- +goose Up
+-- +goose StatementBegin
+-- listings rows are overwritten on repost, the history keeps every price a
+-- space has been listed at and outlives the listings
+create table price_history(
+      id bigserial PRIMARY KEY,
+      name varchar(63) not null,
+      price bigint not null,
+      seller varchar(150) not null,
+      signature BYTEA not null,
+      height integer not null,
+      timestamp BIGINT NOT NULL DEFAULT EXTRACT(EPOCH FROM NOW())::BIGINT
+);
+
+insert into price_history (name, price, seller, signature, height, timestamp)
+select name, price, seller, signature, coalesce((select max(height) from blocks), 0), timestamp
+from listings
+order by timestamp;
+
+create function record_price_history() returns trigger as $$
+begin
+      if TG_OP = 'UPDATE' and OLD.price = NEW.price and OLD.name = NEW.name then
+            return null;
+      end if;
+      insert into price_history (name, price, seller, signature, height)
+      values (NEW.name, NEW.price, NEW.seller, NEW.signature,
+              coalesce((select max(height) from blocks), 0));
+      return null;
+end;
+$$ language plpgsql;
+
+create trigger listings_price_history
+after insert or update of price, name on listings
+for each row execute function record_price_history();
+
+CREATE INDEX price_history_index_name ON price_history(name, timestamp);
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP trigger listings_price_history on listings;
+DROP function record_price_history;
+DROP index price_history_index_name;
+DROP table price_history;
+-- +goose StatementEnd
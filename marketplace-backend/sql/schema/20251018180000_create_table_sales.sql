@@ -0,0 +1,34 @@
+-- +goose Up
+-- +goose StatementBegin
+-- sales are the listings filled on chain, signature is the filled listing
+-- and is kept after the listing is removed
+create table sales(
+      id bigserial PRIMARY KEY,
+      name varchar(63) not null,
+      price bigint not null,
+      seller varchar(150) not null,
+      buyer varchar(150) not null,
+      signature BYTEA not null,
+      txid BYTEA not null,
+      height integer not null,
+      timestamp BIGINT NOT NULL DEFAULT EXTRACT(EPOCH FROM NOW())::BIGINT,
+      unique (txid, name)
+);
+
+CREATE INDEX sales_index_name ON sales(name);
+CREATE INDEX sales_index_height ON sales(height);
+
+-- sales are served with the listings cache validators
+create trigger sales_bump_version
+after insert or update or delete on sales
+for each statement execute function bump_listings_version();
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP trigger sales_bump_version on sales;
+DROP index sales_index_height;
+DROP index sales_index_name;
+DROP table sales;
+-- +goose StatementEnd
@@ -0,0 +1,29 @@
+-- +goose Up
+-- +goose StatementBegin
+-- chain state of the spaces touched by the indexed blocks, names are stored
+-- without the @ like the listings
+create table spaces(
+      name varchar(63) PRIMARY KEY,
+      outpoint_txid BYTEA not null,
+      outpoint_n integer not null,
+      last_update_height integer not null,
+      expire_height integer not null default 0,
+      last_event varchar(16) not null,
+      updated_at BIGINT NOT NULL DEFAULT EXTRACT(EPOCH FROM NOW())::BIGINT
+);
+
+CREATE INDEX spaces_index_last_update_height ON spaces(last_update_height);
+
+-- the chain state is served with the listings cache validators
+create trigger spaces_bump_version
+after insert or update or delete on spaces
+for each statement execute function bump_listings_version();
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP trigger spaces_bump_version on spaces;
+DROP index spaces_index_last_update_height;
+DROP table spaces;
+-- +goose StatementEnd
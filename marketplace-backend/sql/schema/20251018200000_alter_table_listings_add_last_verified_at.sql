@@ -0,0 +1,26 @@
+-- +goose Up
+-- +goose StatementBegin
+alter table listings
+      add column last_verified_at BIGINT not null default 0;
+
+CREATE INDEX listings_index_last_verified_at ON listings(last_verified_at) WHERE valid = true;
+
+-- recording a verification does not change what the api serves
+DROP trigger listings_bump_version on listings;
+create trigger listings_bump_version
+after insert or delete or update of name, price, seller, signature, height, valid on listings
+for each statement execute function bump_listings_version();
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP trigger listings_bump_version on listings;
+create trigger listings_bump_version
+after insert or update or delete on listings
+for each statement execute function bump_listings_version();
+
+DROP index listings_index_last_verified_at;
+alter table listings
+      drop column last_verified_at;
+-- +goose StatementEnd
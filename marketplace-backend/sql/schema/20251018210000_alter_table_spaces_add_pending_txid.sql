@@ -0,0 +1,13 @@
+-- +goose Up
+-- +goose StatementBegin
+-- pending_txid is the mempool transaction spending the space outpoint, empty
+-- when no transfer is pending
+alter table spaces
+      add column pending_txid BYTEA not null default ''::bytea;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+alter table spaces
+      drop column pending_txid;
+-- +goose StatementEnd
@@ -0,0 +1,90 @@
+-- +goose Up
+-- +goose StatementBegin
+-- every writing transaction records one change row instead of bumping the
+-- shared listings_version row, which was locked until the writer committed.
+-- the row becomes visible when the transaction commits, so the count of the
+-- rows changes exactly when the served content does.
+create table listings_changes(
+      xact_id bigint PRIMARY KEY,
+      changed_at BIGINT NOT NULL
+);
+
+-- deferred to the commit so that changed_at is the commit time and not the
+-- start of a long transaction
+create function record_listings_change() returns trigger as $$
+begin
+      insert into listings_changes(xact_id, changed_at)
+      values (pg_current_xact_id()::text::bigint, EXTRACT(EPOCH FROM clock_timestamp())::BIGINT)
+      on conflict (xact_id) do update
+      set changed_at = excluded.changed_at
+      where listings_changes.changed_at < excluded.changed_at;
+      return null;
+end;
+$$ language plpgsql;
+
+DROP trigger listings_bump_version on listings;
+DROP trigger listing_events_bump_version on listing_events;
+DROP trigger sales_bump_version on sales;
+DROP trigger spaces_bump_version on spaces;
+DROP function bump_listings_version;
+
+create constraint trigger listings_record_change
+after insert or delete or update of name, price, seller, signature, height, valid on listings
+deferrable initially deferred
+for each row execute function record_listings_change();
+
+create constraint trigger listing_events_record_change
+after insert or update or delete on listing_events
+deferrable initially deferred
+for each row execute function record_listings_change();
+
+create constraint trigger sales_record_change
+after insert or update or delete on sales
+deferrable initially deferred
+for each row execute function record_listings_change();
+
+create constraint trigger spaces_record_change
+after insert or update or delete on spaces
+deferrable initially deferred
+for each row execute function record_listings_change();
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP trigger spaces_record_change on spaces;
+DROP trigger sales_record_change on sales;
+DROP trigger listing_events_record_change on listing_events;
+DROP trigger listings_record_change on listings;
+DROP function record_listings_change;
+
+update listings_version
+set version = version + (select count(*) from listings_changes),
+    updated_at = greatest(updated_at, (select COALESCE(max(changed_at), 0) from listings_changes));
+DROP table listings_changes;
+
+create function bump_listings_version() returns trigger as $$
+begin
+      update listings_version
+      set version = version + 1,
+          updated_at = EXTRACT(EPOCH FROM NOW())::BIGINT;
+      return null;
+end;
+$$ language plpgsql;
+
+create trigger listings_bump_version
+after insert or delete or update of name, price, seller, signature, height, valid on listings
+for each statement execute function bump_listings_version();
+
+create trigger listing_events_bump_version
+after insert or update or delete on listing_events
+for each statement execute function bump_listings_version();
+
+create trigger sales_bump_version
+after insert or update or delete on sales
+for each statement execute function bump_listings_version();
+
+create trigger spaces_bump_version
+after insert or update or delete on spaces
+for each statement execute function bump_listings_version();
+-- +goose StatementEnd
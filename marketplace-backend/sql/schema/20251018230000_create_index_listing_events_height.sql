@@ -0,0 +1,10 @@
+-- +goose Up
+-- +goose StatementBegin
+-- a rollback looks up the listings changed above the fork point
+CREATE INDEX listing_events_index_height ON listing_events(height);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP index listing_events_index_height;
+-- +goose StatementEnd
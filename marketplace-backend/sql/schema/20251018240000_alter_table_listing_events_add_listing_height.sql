@@ -0,0 +1,38 @@
+-- +goose Up
+-- +goose StatementBegin
+-- with the height and the timestamp of the listing the events carry its
+-- whole state, a replica can be rebuilt from the change feed alone
+alter table listing_events
+      add column listing_height integer not null default 0,
+      add column listing_timestamp bigint not null default 0;
+
+update listing_events e
+set listing_height = l.height, listing_timestamp = l.timestamp
+from listings l
+where l.signature = e.signature;
+
+create or replace function snapshot_listing_event() returns trigger as $$
+begin
+      select price, seller, valid, height, timestamp
+      into NEW.price, NEW.seller, NEW.valid, NEW.listing_height, NEW.listing_timestamp
+      from listings where signature = NEW.signature;
+      return NEW;
+end;
+$$ language plpgsql;
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+create or replace function snapshot_listing_event() returns trigger as $$
+begin
+      select price, seller, valid into NEW.price, NEW.seller, NEW.valid
+      from listings where signature = NEW.signature;
+      return NEW;
+end;
+$$ language plpgsql;
+
+alter table listing_events
+      drop column listing_height,
+      drop column listing_timestamp;
+-- +goose StatementEnd